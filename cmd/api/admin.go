@@ -0,0 +1,382 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"greenlight.kerseeehuang.com/internal/audit"
+	"greenlight.kerseeehuang.com/internal/data"
+	"greenlight.kerseeehuang.com/internal/validator"
+)
+
+// listUsersHandler lists the users with given query in r.URL.Values.
+func (app *application) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name      string
+		Email     string
+		Activated *bool
+		data.Filters
+	}
+
+	// Prepare a validator.
+	v := validator.New()
+
+	// Get the query.
+	qs := r.URL.Query()
+
+	// Populate the input struct.
+	input.Name = app.readString(qs, "name", "")
+	input.Email = app.readString(qs, "email", "")
+	input.Activated = app.readBool(qs, "activated", nil, v)
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "name", "email", "create_at", "-id", "-name", "-email", "-create_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Get all results from DB based on given input.
+	users, metadata, err := app.models.Users.GetAll(input.Name, input.Email, input.Activated, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Write the users to response.
+	err = app.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "users": users}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showUserHandler shows a user with the roles and the effective permissions of the user.
+func (app *application) showUserHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the user.
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	// Get the roles and permissions of this user.
+	roles, err := app.models.Roles.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Write the user to response.
+	env := envelope{"user": user, "roles": roles, "permissions": permissions}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// grantUserPermissionHandler grants the permission code in the request to a user.
+func (app *application) grantUserPermissionHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the user.
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	// Parse the input.
+	var input struct {
+		Code string `json:"code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Validate the permission code.
+	codes, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Check(input.Code != "", "code", validator.ErrMsgMustBeProvided)
+	v.Check(codes.Include(input.Code), "code", "invalid permission code")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Grant the permission.
+	err = app.models.Permissions.AddForUser(user.ID, input.Code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Record the grant.
+	app.recordAuditEvent(r, audit.Event{Action: audit.ActionPermissionGrant, TargetType: audit.TargetUser, TargetID: user.ID, After: map[string]string{"permission": input.Code}})
+
+	app.writeUserPermissionsResponse(w, r, user, fmt.Sprintf("permission %s successfully granted", input.Code))
+}
+
+// revokeUserPermissionHandler revokes the permission code in the URL from a user.
+// Only permissions granted directly to the user can be revoked, permissions granted
+// by roles are revoked by unassigning the roles.
+func (app *application) revokeUserPermissionHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the user.
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	// Admins cannot revoke their own admin permission.
+	code := app.readStringParam(r, "code")
+	if user.ID == app.contextGetUser(r).ID && code == data.PermissionAdminUsers {
+		app.failedValidationResponse(w, r, map[string]string{"code": "cannot revoke your own admin permission"})
+		return
+	}
+
+	// Revoke the permission.
+	err := app.models.Permissions.RemoveForUser(user.ID, code)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrLastAdmin):
+			app.lastAdminResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Record the revocation.
+	app.recordAuditEvent(r, audit.Event{Action: audit.ActionPermissionRevoke, TargetType: audit.TargetUser, TargetID: user.ID, Before: map[string]string{"permission": code}})
+
+	app.writeUserPermissionsResponse(w, r, user, fmt.Sprintf("permission %s successfully revoked", code))
+}
+
+// assignUserRoleHandler assigns the role in the request to a user.
+func (app *application) assignUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the user.
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	// Parse the input.
+	var input struct {
+		Role string `json:"role"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Validate the role.
+	roles, err := app.models.Roles.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Check(input.Role != "", "role", validator.ErrMsgMustBeProvided)
+	v.Check(roles.Include(input.Role), "role", "invalid role")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Assign the role.
+	err = app.models.Roles.AddForUser(user.ID, input.Role)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Record the assignment.
+	app.recordAuditEvent(r, audit.Event{Action: audit.ActionRoleAssign, TargetType: audit.TargetUser, TargetID: user.ID, After: map[string]string{"role": input.Role}})
+
+	app.writeUserPermissionsResponse(w, r, user, fmt.Sprintf("role %s successfully assigned", input.Role))
+}
+
+// unassignUserRoleHandler unassigns the role in the URL from a user.
+func (app *application) unassignUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the user.
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	// Admins cannot unassign their own admin role, which grants the admin permission.
+	role := app.readStringParam(r, "role")
+	if user.ID == app.contextGetUser(r).ID && role == data.RoleAdmin {
+		app.failedValidationResponse(w, r, map[string]string{"role": "cannot unassign your own admin role"})
+		return
+	}
+
+	// Unassign the role.
+	err := app.models.Roles.RemoveForUser(user.ID, role)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrLastAdmin):
+			app.lastAdminResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Record the unassignment.
+	app.recordAuditEvent(r, audit.Event{Action: audit.ActionRoleUnassign, TargetType: audit.TargetUser, TargetID: user.ID, Before: map[string]string{"role": role}})
+
+	app.writeUserPermissionsResponse(w, r, user, fmt.Sprintf("role %s successfully unassigned", role))
+}
+
+// updateUserActivationHandler activates or deactivates a user.
+func (app *application) updateUserActivationHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the user.
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	// Parse the input.
+	var input struct {
+		Activated *bool `json:"activated"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Validate the input.
+	v := validator.New()
+	v.Check(input.Activated != nil, "activated", validator.ErrMsgMustBeProvided)
+	v.Check(input.Activated == nil || *input.Activated || user.ID != app.contextGetUser(r).ID, "activated", "cannot deactivate your own account")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Update the user activation.
+	before := map[string]bool{"activated": user.Activated}
+	user.Activated = *input.Activated
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrLastAdmin):
+			app.lastAdminResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Record the changes.
+	app.recordAuditEvent(r, audit.Event{Action: audit.ActionUserActivation, TargetType: audit.TargetUser, TargetID: user.ID, Before: before, After: map[string]bool{"activated": user.Activated}})
+
+	// Revoke the signed tokens carrying the previous activation state.
+	err = app.revokeSignedTokensForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Send updated details to the client.
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteUserTokensHandler forces a user to log out by revoking all
+// authentication and refresh tokens and API keys of the user.
+func (app *application) deleteUserTokensHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the user.
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	// Revoke all tokens of this user.
+	err := app.revokeAllSessions(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Record the revocation.
+	app.recordAuditEvent(r, audit.Event{Action: audit.ActionTokenRevokeAll, TargetType: audit.TargetUser, TargetID: user.ID})
+
+	// Inform the client that the tokens are revoked.
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "all authentication tokens and API keys of the user successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readUserParam retrieves the user with the id in the URL from DB.
+// If the user cannot be retrieved, it sends the error response and returns false.
+func (app *application) readUserParam(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	// Read the user id in the request.
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	// Fetch the user from DB with given id.
+	user, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return user, true
+}
+
+// writeUserPermissionsResponse revokes the signed tokens of the user carrying the
+// outdated permissions, and sends the message with the latest roles and effective
+// permissions of the user to the client.
+func (app *application) writeUserPermissionsResponse(w http.ResponseWriter, r *http.Request, user *data.User, message string) {
+	// Revoke the signed tokens carrying the outdated permissions.
+	err := app.revokeSignedTokensForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Get the roles and permissions of this user.
+	roles, err := app.models.Roles.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Write the message and the permissions to response.
+	env := envelope{"message": message, "roles": roles, "permissions": permissions}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
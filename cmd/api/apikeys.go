@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"greenlight.kerseeehuang.com/internal/audit"
+	"greenlight.kerseeehuang.com/internal/data"
+	"greenlight.kerseeehuang.com/internal/validator"
+)
+
+// listAPIKeysHandler lists the API keys of the authenticated user.
+func (app *application) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the user.
+	user := app.contextGetUser(r)
+
+	// Get all API keys of this user.
+	keys, err := app.models.APIKeys.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Send the keys to the client.
+	err = app.writeJSON(w, http.StatusOK, envelope{"api_keys": keys}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createAPIKeyHandler creates a new named API key for the authenticated user, which is
+// allowed the given subset of the permissions of the user, or all of them if omitted.
+// The plaintext of the key is only sent in this response.
+func (app *application) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse the input.
+	var input struct {
+		Name        string           `json:"name"`
+		Permissions data.Permissions `json:"permissions"`
+		Expiry      *time.Time       `json:"expiry"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Generate a new key.
+	user := app.contextGetUser(r)
+	key, err := data.NewAPIKey(user.ID, input.Name, input.Permissions, input.Expiry)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Validate the key.
+	v := validator.New()
+	if data.ValidateAPIKey(v, key); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Check that the key is only allowed the permissions of the user.
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	for _, code := range key.Permissions {
+		v.Check(permissions.Include(code), "permissions", "must be a subset of your permissions")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Insert the key into DB.
+	err = app.models.APIKeys.Insert(key)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Send the key to the client.
+	err = app.writeJSON(w, http.StatusCreated, envelope{"api_key": key}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteAPIKeyHandler revokes the API key with the id in the URL of the authenticated user.
+func (app *application) deleteAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	// Read the key id in the request.
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// Delete the key of this user.
+	user := app.contextGetUser(r)
+	err = app.models.APIKeys.Delete(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Record the revocation.
+	app.recordAuditEvent(r, audit.Event{Action: audit.ActionAPIKeyDelete, TargetType: audit.TargetAPIKey, TargetID: id})
+
+	// Inform the client that the key is revoked.
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "API key successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
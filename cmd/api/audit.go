@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"greenlight.kerseeehuang.com/internal/audit"
+	"greenlight.kerseeehuang.com/internal/data"
+	"greenlight.kerseeehuang.com/internal/validator"
+)
+
+// recordAuditEvent records the audit event of request r. The actor is the user of r
+// unless event.ActorID is set. Failures are only logged, since the action itself has
+// already been done.
+func (app *application) recordAuditEvent(r *http.Request, event audit.Event) {
+	// Use the authenticated user of this request as the actor.
+	if user := app.contextGetUser(r); event.ActorID == 0 && !user.IsAnonymous() {
+		event.ActorID = user.ID
+	}
+
+	err := app.audit.Record(r, event)
+	if err != nil {
+		app.logger.PrintError(err.Error(), map[string]string{
+			"action":    event.Action,
+			"target_id": strconv.FormatInt(event.TargetID, 10),
+		})
+	}
+}
+
+// listAuditEventsHandler lists the audit events with given query in r.URL.Values.
+func (app *application) listAuditEventsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.AuditEventFilter
+		data.Filters
+	}
+
+	// Prepare a validator.
+	v := validator.New()
+
+	// Get the query.
+	qs := r.URL.Query()
+
+	// Populate the input struct.
+	input.ActorID = app.readInt64(qs, "actor_id", v)
+	input.Action = app.readString(qs, "action", "")
+	input.TargetType = app.readString(qs, "target_type", "")
+	input.TargetID = app.readString(qs, "target_id", "")
+	input.Since = app.readTime(qs, "since", v)
+	input.Until = app.readTime(qs, "until", v)
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-created_at")
+	input.Filters.SortSafelist = []string{"id", "created_at", "action", "-id", "-created_at", "-action"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Get all results from DB based on given input.
+	events, metadata, err := app.models.AuditEvents.GetAll(input.AuditEventFilter, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Write the audit events to response.
+	err = app.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "audit_events": events}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
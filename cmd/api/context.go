@@ -10,7 +10,11 @@ import (
 // contextKey is a customized type of context key
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey        = contextKey("user")
+	tokenContextKey       = contextKey("token")
+	permissionsContextKey = contextKey("permissions")
+)
 
 // contextSetUser returns a child context with adding user to r by calling r.WithContext.
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
@@ -28,3 +32,37 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+// contextSetToken returns a child context with adding the plaintext of
+// the authentication token to r by calling r.WithContext.
+func (app *application) contextSetToken(r *http.Request, token string) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenContextKey, token)
+	return r.WithContext(ctx)
+}
+
+// contextGetToken retrieves the plaintext of the authentication token from r.
+// It assumes that a token exists in the request r.Context, which is true for
+// all requests of authenticated users.
+// Calling with a request that has no token will cause panic.
+func (app *application) contextGetToken(r *http.Request) string {
+	token, ok := r.Context().Value(tokenContextKey).(string)
+	if !ok {
+		panic("missing token value in request context")
+	}
+	return token
+}
+
+// contextSetPermissions returns a child context with adding the permissions
+// carried by the authentication token to r by calling r.WithContext.
+func (app *application) contextSetPermissions(r *http.Request, permissions data.Permissions) *http.Request {
+	ctx := context.WithValue(r.Context(), permissionsContextKey, permissions)
+	return r.WithContext(ctx)
+}
+
+// contextGetPermissions retrieves the permissions carried by the authentication token from r.
+// Unlike the user and the token, the permissions are optional, hence ok is false if
+// there are no permissions in the request r.Context.
+func (app *application) contextGetPermissions(r *http.Request) (permissions data.Permissions, ok bool) {
+	permissions, ok = r.Context().Value(permissionsContextKey).(data.Permissions)
+	return permissions, ok
+}
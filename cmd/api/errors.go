@@ -2,7 +2,10 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 // logError log the error via app.logger.
@@ -63,6 +66,13 @@ func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Requ
 	app.errorResponse(w, r, http.StatusConflict, msg)
 }
 
+// lastAdminResponse sends the Conflict Error response to the client.
+// Called when a change would leave no activated user with the users:admin permission.
+func (app *application) lastAdminResponse(w http.ResponseWriter, r *http.Request) {
+	msg := "unable to remove the last activated user with the users:admin permission"
+	app.errorResponse(w, r, http.StatusConflict, msg)
+}
+
 // rateLimitExceededResponse sends the Rate Limit Exceed Error response to the client.
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
 	msg := "rate limit exceeded"
@@ -75,6 +85,13 @@ func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *htt
 	app.errorResponse(w, r, http.StatusUnauthorized, msg)
 }
 
+// apiKeyNotAllowedResponse sends the Status Forbidden Error response to the client
+// authenticated with an API key.
+func (app *application) apiKeyNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
+	msg := "this resource cannot be accessed with an API key"
+	app.errorResponse(w, r, http.StatusForbidden, msg)
+}
+
 // invalidAuthenticationTokenResponse adds "WWW-Authenticate":"Bearer" into response header
 // and sends the Status Unauthorized Error response to the client.
 func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
@@ -104,3 +121,13 @@ func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Requ
 	msg := "your account has no permission for this resource"
 	app.errorResponse(w, r, http.StatusForbidden, msg)
 }
+
+// loginThrottledResponse adds "Retry-After" into response header and sends the
+// Too Many Requests Error response to the client.
+// Called when there are too many failed logins for the account or the client.
+func (app *application) loginThrottledResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+
+	msg := "too many failed login attempts, please try again later"
+	app.errorResponse(w, r, http.StatusTooManyRequests, msg)
+}
@@ -9,6 +9,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"greenlight.kerseeehuang.com/internal/validator"
@@ -32,6 +33,14 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
+// readStringParam retrieves the URL parameter with given name in http.Request.
+func (app *application) readStringParam(r *http.Request, name string) string {
+	// Get the params in the request context.
+	params := httprouter.ParamsFromContext(r.Context())
+
+	return params.ByName(name)
+}
+
 // writeJSON is a helper for sending responses in JSON.
 func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
 	// Encode the data to JSON.
@@ -166,6 +175,63 @@ func (app *application) readInt(qs url.Values, key string, defaultVal int, v *va
 	return intVal
 }
 
+// readBool reads the value from qs with given key and parses the value from string to bool.
+// If the given key does not exist in qs, return defaultVal.
+// If parsing error happens, store error messages into v.
+func (app *application) readBool(qs url.Values, key string, defaultVal *bool, v *validator.Validator) *bool {
+	// Extract value from qs.
+	val := qs.Get(key)
+	if val == "" {
+		return defaultVal
+	}
+
+	// Parse the value.
+	boolVal, err := strconv.ParseBool(val)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultVal
+	}
+	return &boolVal
+}
+
+// readInt64 reads the value from qs with given key and parses the value from string to int64.
+// If the given key does not exist in qs, return nil.
+// If parsing error happens, store error messages into v.
+func (app *application) readInt64(qs url.Values, key string, v *validator.Validator) *int64 {
+	// Extract value from qs.
+	val := qs.Get(key)
+	if val == "" {
+		return nil
+	}
+
+	// Parse the value.
+	intVal, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		v.AddError(key, "must be integer")
+		return nil
+	}
+	return &intVal
+}
+
+// readTime reads the value from qs with given key and parses the value from an RFC 3339 string to time.
+// If the given key does not exist in qs, return nil.
+// If parsing error happens, store error messages into v.
+func (app *application) readTime(qs url.Values, key string, v *validator.Validator) *time.Time {
+	// Extract value from qs.
+	val := qs.Get(key)
+	if val == "" {
+		return nil
+	}
+
+	// Parse the value.
+	timeVal, err := time.Parse(time.RFC3339, val)
+	if err != nil {
+		v.AddError(key, "must be a time in RFC 3339 format")
+		return nil
+	}
+	return &timeVal
+}
+
 // background opens a goroutine to execute f with recover.
 func (app *application) background(f func()) {
 	app.wg.Add(1)
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// runPeriodically opens a goroutine to execute the job f every interval until the
+// application shuts down. Errors and panics of f are logged with the job name,
+// and the job keeps running.
+func (app *application) runPeriodically(name string, interval time.Duration, f func() error) {
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-app.shutdown:
+				return
+			case <-ticker.C:
+				app.runJob(name, f)
+			}
+		}
+	}()
+}
+
+// runJob executes the job f once with recover, and logs the returning error.
+func (app *application) runJob(name string, f func() error) {
+	// Recover from panic.
+	defer func() {
+		if err := recover(); err != nil {
+			app.logger.PrintError(fmt.Errorf("%s", err).Error(), map[string]string{"job": name})
+		}
+	}()
+
+	// Execute the job f.
+	err := f()
+	if err != nil {
+		app.logger.PrintError(err.Error(), map[string]string{"job": name})
+	}
+}
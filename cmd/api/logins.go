@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tomasen/realip"
+	"greenlight.kerseeehuang.com/internal/audit"
+	"greenlight.kerseeehuang.com/internal/data"
+)
+
+// loginRetryAfter returns how long the client of request r has to wait before it may try
+// to log in to the account with email again, or zero if the login attempt is allowed.
+// Logins are refused while the account is locked, while the IP address of the client has
+// too many failed logins, or during the back-off which doubles after each failed login.
+func (app *application) loginRetryAfter(r *http.Request, email string) (time.Duration, error) {
+	now := time.Now()
+	since := now.Add(-app.config.login.failureWindow)
+
+	// Check the lockout of the account.
+	lockedUntil, err := app.models.Logins.GetLockout(email)
+	switch {
+	case err == nil:
+		return lockedUntil.Sub(now), nil
+	case !errors.Is(err, data.ErrRecordNotFound):
+		return 0, err
+	}
+
+	// Check the failed logins from the IP address.
+	ipFailures, err := app.models.Logins.GetFailuresForIP(realip.FromRequest(r), since)
+	if err != nil {
+		return 0, err
+	}
+	if ipFailures.Count >= app.config.login.maxIPFailures {
+		return ipFailures.Last.Add(app.config.login.failureWindow).Sub(now), nil
+	}
+
+	// Check the back-off of the account.
+	failures, err := app.models.Logins.GetFailuresForEmail(email, since)
+	if err != nil {
+		return 0, err
+	}
+	if failures.Count == 0 {
+		return 0, nil
+	}
+	backoff := app.config.login.lockout
+	if shift := failures.Count - 1; shift < 32 && app.config.login.backoff<<shift < backoff {
+		backoff = app.config.login.backoff << shift
+	}
+	if retryAfter := failures.Last.Add(backoff).Sub(now); retryAfter > 0 {
+		return retryAfter, nil
+	}
+
+	return 0, nil
+}
+
+// recordLoginFailure records a failed login to the account with email from the client of
+// request r, and locks the account if it has too many failed logins. The user is nil if
+// no user has the email, in which case the account is locked in the same way but no
+// notification is sent.
+func (app *application) recordLoginFailure(r *http.Request, email string, user *data.User) error {
+	ip := realip.FromRequest(r)
+
+	// Record the failed login.
+	err := app.models.Logins.RecordFailure(email, ip)
+	if err != nil {
+		return err
+	}
+
+	// Count the failed logins of the account.
+	failures, err := app.models.Logins.GetFailuresForEmail(email, time.Now().Add(-app.config.login.failureWindow))
+	if err != nil {
+		return err
+	}
+	if failures.Count < app.config.login.maxFailures {
+		return nil
+	}
+
+	// Lock the account.
+	lockedUntil := time.Now().Add(app.config.login.lockout)
+	err = app.models.Logins.Lock(email, lockedUntil)
+	if err != nil {
+		return err
+	}
+
+	// Notify the user about the lockout in the background.
+	if user != nil {
+		app.background(func() {
+			data := map[string]interface{}{
+				"lockedUntil": lockedUntil.Format(time.RFC1123),
+				"ip":          ip,
+			}
+
+			err := app.mailer.Send(user.Email, "account_locked.tmpl", data)
+			if err != nil {
+				app.logger.PrintError(err.Error(), nil)
+			}
+		})
+	}
+
+	return nil
+}
+
+// failedLoginResponse records the failed login and sends the Status Unauthorized Error response to the client.
+func (app *application) failedLoginResponse(w http.ResponseWriter, r *http.Request, email string, user *data.User) {
+	err := app.recordLoginFailure(r, email, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Record the failure with the user ID if a user has the email. Otherwise record a hash of
+	// the email, so that failures for the same email can be correlated without storing it.
+	event := audit.Event{Action: audit.ActionLoginFailure, TargetType: audit.TargetUser}
+	if user != nil {
+		event.TargetID = user.ID
+	} else {
+		hash := sha256.Sum256([]byte(strings.ToLower(email)))
+		event.After = map[string]string{"email_sha256": hex.EncodeToString(hash[:])}
+	}
+	app.recordAuditEvent(r, event)
+
+	app.invalidCredentialsResponse(w, r)
+}
+
+// deleteExpiredLoginFailures deletes the failed logins which are no longer counted and the expired lockouts.
+func (app *application) deleteExpiredLoginFailures() error {
+	return app.models.Logins.DeleteExpired(time.Now().Add(-app.config.login.failureWindow))
+}
+
+// unlockUserHandler removes the lockout and the failed logins of a user.
+func (app *application) unlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the user.
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	// Unlock the account of this user.
+	err := app.models.Logins.Unlock(user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Record the unlock.
+	app.recordAuditEvent(r, audit.Event{Action: audit.ActionUserUnlock, TargetType: audit.TargetUser, TargetID: user.ID})
+
+	// Inform the client that the account is unlocked.
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "user account successfully unlocked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"database/sql"
+	"encoding/base64"
+	"errors"
 	"expvar"
 	"flag"
 	"fmt"
@@ -13,9 +15,13 @@ import (
 	"time"
 
 	_ "github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+	"greenlight.kerseeehuang.com/internal/audit"
 	"greenlight.kerseeehuang.com/internal/data"
 	"greenlight.kerseeehuang.com/internal/jsonlog"
+	"greenlight.kerseeehuang.com/internal/jwt"
 	"greenlight.kerseeehuang.com/internal/mailer"
+	"greenlight.kerseeehuang.com/internal/oidc"
 )
 
 var (
@@ -35,9 +41,12 @@ type config struct {
 	}
 	// limiter holds configuration settings for the rate limiter.
 	limiter struct {
-		rps     float64 // rate limit per second
-		burst   int
-		enabled bool
+		rps            float64 // rate limit per second
+		burst          int
+		enabled        bool
+		suggestRPS     float64 // rate limit per second of movie suggestions, which replaces the global one
+		suggestBurst   int
+		suggestEnabled bool
 	}
 	// smtp holds configuration settings for the SMTP server.
 	smtp struct {
@@ -51,6 +60,66 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+	// tokens holds the lifetimes of tokens of each scope.
+	tokens struct {
+		activationTTL     time.Duration
+		authenticationTTL time.Duration
+		refreshTTL        time.Duration
+		passwordResetTTL  time.Duration
+		emailChangeTTL    time.Duration
+		mfaTTL            time.Duration
+		magicLinkTTL      time.Duration
+	}
+	// mfa holds configuration settings for the TOTP two-factor authentication.
+	mfa struct {
+		issuer        string // issuer shown in authenticator apps
+		recoveryCodes int    // number of recovery codes generated on enrollment
+	}
+	// users holds configuration settings for user accounts.
+	users struct {
+		deletionGracePeriod time.Duration // delay before a user scheduled for deletion is deleted
+		deletionInterval    time.Duration // interval of deleting the users scheduled for deletion
+	}
+	// movies holds configuration settings for the trash of movies.
+	movies struct {
+		trashRetention time.Duration // delay before a trashed movie is permanently deleted
+		purgeInterval  time.Duration // interval of permanently deleting the expired trashed movies
+	}
+	// password holds configuration settings for the policy of new passwords.
+	password struct {
+		minLength        int    // minimum length in bytes
+		minCharClasses   int    // minimum number of character classes
+		disallowPersonal bool   // disallow the email address and the name of the user in passwords
+		breachCheck      bool   // disallow common and breached passwords
+		breachList       string // file or directory of additional breached password hashes
+		hasher           string // algorithm for hashing new passwords, bcrypt or pbkdf2-sha256
+		bcryptCost       int    // cost of bcrypt hashes
+		pbkdf2Iterations int    // iterations of PBKDF2 hashes
+	}
+	// login holds configuration settings for the brute-force protection of logins.
+	login struct {
+		maxFailures   int           // failed logins of an account before it is locked
+		maxIPFailures int           // failed logins from an IP address before it is blocked
+		failureWindow time.Duration // period in which failed logins are counted
+		lockout       time.Duration // duration of an account lockout
+		backoff       time.Duration // delay after the first failed login, doubled after each failure
+	}
+	// auth holds configuration settings for authentication tokens.
+	auth struct {
+		tokenMode    string            // "db" for DB-backed tokens or "signed" for signed stateless tokens
+		signingAlg   string            // algorithm of signed tokens, HS256 or EdDSA
+		signingKeys  map[string][]byte // HMAC secrets or Ed25519 seeds by key ID
+		signingKeyID string            // ID of the key used for signing new tokens
+		denyListSync time.Duration     // interval of syncing the deny-list of signed tokens from DB
+	}
+	// oidc holds configuration settings for logging in with an OpenID Connect provider.
+	oidc struct {
+		issuer       string        // issuer URL of the provider, OpenID Connect login is disabled if empty
+		clientID     string        // client ID registered at the provider
+		clientSecret string        // client secret registered at the provider
+		redirectURL  string        // URL of the callback endpoint registered at the provider
+		loginTTL     time.Duration // time limit of finishing the login at the provider
+	}
 }
 
 // application holds the dependencies for HTTP handlers, helpers, loggers and middlewares.
@@ -60,6 +129,25 @@ type application struct {
 	models data.Models
 	mailer mailer.Mailer
 	wg     sync.WaitGroup
+
+	// audit records security-relevant and data-changing actions.
+	audit *audit.Recorder
+
+	// suggestLimiter limits the rate of movie suggestions. It is nil if the rate limiter of suggestions is disabled.
+	suggestLimiter *ipRateLimiter
+
+	// passwordPolicy validates new passwords.
+	passwordPolicy *data.PasswordPolicy
+
+	// shutdown is closed when the server is shutting down to stop the periodic jobs.
+	shutdown chan struct{}
+
+	// signer signs and verifies signed authentication tokens. It is nil in "db" token mode.
+	signer   *jwt.KeySet
+	denyList *denyList
+
+	// oidc is the OpenID Connect provider for logins. It is nil if OpenID Connect login is disabled.
+	oidc *oidc.Provider
 }
 
 func main() {
@@ -78,6 +166,9 @@ func main() {
 	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second ")
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate Limiter maximum burst")
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	flag.Float64Var(&cfg.limiter.suggestRPS, "limiter-suggest-rps", 10, "Rate limiter maximum requests per second of movie suggestions")
+	flag.IntVar(&cfg.limiter.suggestBurst, "limiter-suggest-burst", 20, "Rate limiter maximum burst of movie suggestions")
+	flag.BoolVar(&cfg.limiter.suggestEnabled, "limiter-suggest-enabled", true, "Enable rate limiter of movie suggestions")
 
 	flag.StringVar(&cfg.smtp.host, "smtp-host", "smtp.mailtrap.io", "SMTP host")
 	flag.IntVar(&cfg.smtp.port, "smtp-port", 25, "SMTP port")
@@ -90,6 +181,65 @@ func main() {
 		return nil
 	})
 
+	flag.DurationVar(&cfg.tokens.activationTTL, "token-activation-ttl", 3*24*time.Hour, "Lifetime of activation tokens")
+	flag.DurationVar(&cfg.tokens.authenticationTTL, "token-authentication-ttl", 15*time.Minute, "Lifetime of authentication (access) tokens")
+	flag.DurationVar(&cfg.tokens.refreshTTL, "token-refresh-ttl", 30*24*time.Hour, "Lifetime of refresh tokens")
+	flag.DurationVar(&cfg.tokens.passwordResetTTL, "token-password-reset-ttl", 45*time.Minute, "Lifetime of password reset tokens")
+	flag.DurationVar(&cfg.tokens.emailChangeTTL, "token-email-change-ttl", 24*time.Hour, "Lifetime of email change confirmation tokens")
+	flag.DurationVar(&cfg.tokens.magicLinkTTL, "token-magic-link-ttl", 15*time.Minute, "Lifetime of magic-link login tokens")
+	flag.DurationVar(&cfg.tokens.mfaTTL, "token-mfa-ttl", 5*time.Minute, "Lifetime of intermediate tokens of the two-factor authentication")
+
+	flag.StringVar(&cfg.mfa.issuer, "mfa-issuer", "Greenlight", "Issuer of TOTP secrets shown in authenticator apps")
+	flag.IntVar(&cfg.mfa.recoveryCodes, "mfa-recovery-codes", 10, "Number of recovery codes generated on enabling two-factor authentication")
+
+	flag.DurationVar(&cfg.users.deletionGracePeriod, "user-deletion-grace-period", 30*24*time.Hour, "Grace period before a user scheduled for deletion is deleted")
+	flag.DurationVar(&cfg.users.deletionInterval, "user-deletion-interval", time.Hour, "Interval of deleting the users scheduled for deletion")
+
+	flag.DurationVar(&cfg.movies.trashRetention, "movie-trash-retention", 30*24*time.Hour, "Retention period of trashed movies before they are permanently deleted")
+	flag.DurationVar(&cfg.movies.purgeInterval, "movie-purge-interval", time.Hour, "Interval of permanently deleting the expired trashed movies")
+
+	flag.IntVar(&cfg.password.minLength, "password-min-length", 8, "Minimum length of new passwords in bytes")
+	flag.IntVar(&cfg.password.minCharClasses, "password-min-char-classes", 1, "Minimum number of character classes (lowercase, uppercase, digits, symbols) of new passwords")
+	flag.BoolVar(&cfg.password.disallowPersonal, "password-disallow-personal", true, "Disallow the email address and the name of the user in new passwords")
+	flag.BoolVar(&cfg.password.breachCheck, "password-breach-check", true, "Disallow common and breached passwords")
+	flag.StringVar(&cfg.password.breachList, "password-breach-list", "", "File of SHA-1 hashes or directory of SHA-1 prefix range files of breached passwords")
+
+	flag.StringVar(&cfg.password.hasher, "password-hasher", data.HasherBcrypt, "Algorithm for hashing new passwords (bcrypt|pbkdf2-sha256)")
+	flag.IntVar(&cfg.password.bcryptCost, "password-bcrypt-cost", 12, "Cost of bcrypt password hashes")
+	flag.IntVar(&cfg.password.pbkdf2Iterations, "password-pbkdf2-iterations", 600000, "Iterations of PBKDF2 password hashes")
+
+	flag.IntVar(&cfg.login.maxFailures, "login-max-failures", 5, "Failed logins of an account before it is locked")
+	flag.IntVar(&cfg.login.maxIPFailures, "login-max-ip-failures", 50, "Failed logins from an IP address before it is blocked")
+	flag.DurationVar(&cfg.login.failureWindow, "login-failure-window", 15*time.Minute, "Period in which failed logins are counted")
+	flag.DurationVar(&cfg.login.lockout, "login-lockout", 15*time.Minute, "Duration of an account lockout")
+	flag.DurationVar(&cfg.login.backoff, "login-backoff", time.Second, "Delay after the first failed login, doubled after each failure")
+
+	flag.StringVar(&cfg.auth.tokenMode, "auth-token-mode", authTokenModeDB, "Authentication token mode (db|signed)")
+	flag.StringVar(&cfg.auth.signingAlg, "auth-signing-alg", jwt.AlgorithmHS256, "Algorithm of signed authentication tokens (HS256|EdDSA)")
+	flag.Func("auth-signing-keys", "Keys of signed authentication tokens as space separated id:base64 pairs of HMAC secrets or Ed25519 seeds", func(s string) error {
+		cfg.auth.signingKeys = make(map[string][]byte)
+		for _, pair := range strings.Fields(s) {
+			parts := strings.SplitN(pair, ":", 2)
+			if len(parts) != 2 || parts[0] == "" {
+				return fmt.Errorf("invalid key %q, must be id:base64", pair)
+			}
+			key, err := base64.StdEncoding.DecodeString(parts[1])
+			if err != nil {
+				return fmt.Errorf("invalid base64 of key %q: %w", parts[0], err)
+			}
+			cfg.auth.signingKeys[parts[0]] = key
+		}
+		return nil
+	})
+	flag.StringVar(&cfg.auth.signingKeyID, "auth-signing-key-id", "", "ID of the key for signing new authentication tokens")
+	flag.DurationVar(&cfg.auth.denyListSync, "auth-deny-list-sync", 30*time.Second, "Interval of syncing the deny-list of signed tokens")
+
+	flag.StringVar(&cfg.oidc.issuer, "oidc-issuer", "", "Issuer URL of the OpenID Connect provider (disabled if empty)")
+	flag.StringVar(&cfg.oidc.clientID, "oidc-client-id", "", "OpenID Connect client ID")
+	flag.StringVar(&cfg.oidc.clientSecret, "oidc-client-secret", "", "OpenID Connect client secret")
+	flag.StringVar(&cfg.oidc.redirectURL, "oidc-redirect-url", "http://localhost:8080/v1/oidc/callback", "OpenID Connect redirect URL")
+	flag.DurationVar(&cfg.oidc.loginTTL, "oidc-login-ttl", 10*time.Minute, "Time limit of finishing an OpenID Connect login at the provider")
+
 	displayVersion := flag.Bool("version", false, "Display application version and exit")
 
 	flag.Parse()
@@ -126,12 +276,60 @@ func main() {
 
 	// Create an application with config and logger.
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:   cfg,
+		logger:   logger,
+		models:   data.NewModels(db),
+		mailer:   mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		shutdown: make(chan struct{}),
+	}
+
+	// Prepare the audit log.
+	app.audit = audit.New(app.models.AuditEvents)
+
+	// Prepare the rate limit of movie suggestions, which are requested on each keystroke.
+	if cfg.limiter.suggestEnabled {
+		app.suggestLimiter = newIPRateLimiter(cfg.limiter.suggestRPS, cfg.limiter.suggestBurst)
 	}
 
+	// Set the hasher of new passwords.
+	err = setupPasswordHasher(cfg)
+	if err != nil {
+		logger.PrintFatal(err.Error(), nil)
+	}
+
+	// Prepare the password policy.
+	app.passwordPolicy, err = newPasswordPolicy(cfg)
+	if err != nil {
+		logger.PrintFatal(err.Error(), nil)
+	}
+
+	// Hash the dummy password compared with the passwords of unknown users.
+	err = data.PrepareDummyPassword()
+	if err != nil {
+		logger.PrintFatal(err.Error(), nil)
+	}
+
+	// Prepare the signed authentication tokens if they are enabled.
+	err = app.setupSignedTokens()
+	if err != nil {
+		logger.PrintFatal(err.Error(), nil)
+	}
+
+	// Discover the OpenID Connect provider if it is enabled.
+	err = app.setupOIDC()
+	if err != nil {
+		logger.PrintFatal(err.Error(), nil)
+	}
+
+	// Delete the users scheduled for deletion periodically.
+	app.runPeriodically("delete scheduled users", cfg.users.deletionInterval, app.deleteScheduledUsers)
+
+	// Permanently delete the expired trashed movies periodically.
+	app.runPeriodically("purge trashed movies", cfg.movies.purgeInterval, app.purgeTrashedMovies)
+
+	// Delete the expired failed logins and lockouts periodically.
+	app.runPeriodically("delete expired login failures", cfg.login.failureWindow, app.deleteExpiredLoginFailures)
+
 	// Create a server and serve.
 	err = app.serve()
 	if err != nil {
@@ -169,3 +367,45 @@ func openDB(cfg config) (*sql.DB, error) {
 
 	return db, nil
 }
+
+// setupPasswordHasher validates the password hasher settings in cfg, and sets the hasher of new passwords.
+func setupPasswordHasher(cfg config) error {
+	switch cfg.password.hasher {
+	case data.HasherBcrypt:
+		if cfg.password.bcryptCost < bcrypt.MinCost || cfg.password.bcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+		data.SetPasswordHasher(data.BcryptHasher{Cost: cfg.password.bcryptCost})
+	case data.HasherPBKDF2SHA256:
+		if cfg.password.pbkdf2Iterations < 1 {
+			return errors.New("PBKDF2 iterations must be positive")
+		}
+		data.SetPasswordHasher(data.PBKDF2Hasher{Iterations: cfg.password.pbkdf2Iterations})
+	default:
+		return fmt.Errorf("invalid password hasher %q", cfg.password.hasher)
+	}
+
+	return nil
+}
+
+// newPasswordPolicy creates the policy of new passwords with the settings in cfg,
+// and loads the list of breached passwords if it is given.
+func newPasswordPolicy(cfg config) (*data.PasswordPolicy, error) {
+	policy := &data.PasswordPolicy{
+		MinLength:        cfg.password.minLength,
+		MinCharClasses:   cfg.password.minCharClasses,
+		DisallowPersonal: cfg.password.disallowPersonal,
+	}
+
+	if cfg.password.breachCheck {
+		policy.BreachedPasswords = data.NewBreachedPasswords()
+		if cfg.password.breachList != "" {
+			err := policy.BreachedPasswords.Load(cfg.password.breachList)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return policy, nil
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"greenlight.kerseeehuang.com/internal/data"
+	"greenlight.kerseeehuang.com/internal/totp"
+	"greenlight.kerseeehuang.com/internal/validator"
+)
+
+// createMFAEnrollmentHandler starts enabling two-factor authentication for the authenticated
+// user by generating a new TOTP secret. The secret is not used for logins until the user
+// confirms it with a valid code.
+func (app *application) createMFAEnrollmentHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the user.
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Generate a new secret.
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Store the secret, unless two-factor authentication has been enabled.
+	err = app.models.TOTP.Enroll(user.ID, secret)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			v := validator.New()
+			v.AddError("mfa", "two-factor authentication is already enabled")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Send the secret and the URI for QR codes to the client.
+	env := envelope{
+		"secret":      secret,
+		"otpauth_uri": totp.URI(app.config.mfa.issuer, user.Email, secret),
+	}
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// confirmMFAEnrollmentHandler enables two-factor authentication for the authenticated user
+// after verifying a code of the enrolled secret, and responds the recovery codes.
+func (app *application) confirmMFAEnrollmentHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse the input.
+	var input struct {
+		Code string `json:"code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Validate the code.
+	v := validator.New()
+	if data.ValidateTOTPCode(v, input.Code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Get the enrollment of the user.
+	user := app.contextGetUser(r)
+	settings, err := app.models.TOTP.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("mfa", "two-factor authentication enrollment must be started first")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if settings.Enabled {
+		v.AddError("mfa", "two-factor authentication is already enabled")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Check the code.
+	step, ok, err := totp.Validate(settings.Secret, input.Code, time.Now())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		v.AddError("code", "invalid code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Generate the recovery codes.
+	codes, err := totp.GenerateRecoveryCodes(app.config.mfa.recoveryCodes)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.TOTP.ReplaceRecoveryCodes(user.ID, codes)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Enable two-factor authentication.
+	err = app.models.TOTP.Enable(user.ID, step)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Send the recovery codes to the client. They are only shown once.
+	env := envelope{"message": "two-factor authentication successfully enabled", "recovery_codes": codes}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteMFAHandler disables two-factor authentication for the authenticated user after
+// confirming the password and a TOTP or recovery code.
+func (app *application) deleteMFAHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse the input.
+	var input struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Validate the input.
+	v := validator.New()
+	v.Check(input.Password != "", "password", validator.ErrMsgMustBeProvided)
+	data.ValidateSecondFactor(v, input.Code, input.RecoveryCode)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Get the user.
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Check if the password is correct.
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !match {
+		v.AddError("password", "incorrect password")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Check the second factor.
+	ok, err := app.verifySecondFactor(user.ID, input.Code, input.RecoveryCode)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		v.AddError("code", "invalid code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Disable two-factor authentication.
+	err = app.models.TOTP.Delete(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Inform the client that two-factor authentication is disabled.
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication successfully disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createMFAAuthenticationTokenHandler exchanges the mfa token issued by
+// createAuthenticationTokenHandler and a TOTP or recovery code for a new pair of
+// authentication and refresh token. Invalid codes count as failed logins.
+func (app *application) createMFAAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse the input.
+	var input struct {
+		TokenPlaintext string `json:"mfa_token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Validate the input.
+	v := validator.New()
+	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
+	data.ValidateSecondFactor(v, input.Code, input.RecoveryCode)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Get the user of the mfa token.
+	user, err := app.models.Users.GetForToken(data.ScopeMFA, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Refuse the attempt if there are too many failed logins.
+	retryAfter, err := app.loginRetryAfter(r, user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if retryAfter > 0 {
+		app.loginThrottledResponse(w, r, retryAfter)
+		return
+	}
+
+	// Check the second factor.
+	ok, err := app.verifySecondFactor(user.ID, input.Code, input.RecoveryCode)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		app.failedLoginResponse(w, r, user.Email, user)
+		return
+	}
+
+	// Clear the failed logins and the mfa tokens of this user.
+	err = app.models.Logins.ClearFailures(user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeMFA, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Issue the authentication tokens.
+	app.createAuthenticationTokensResponse(w, r, user.ID)
+}
+
+// verifySecondFactor reports whether the TOTP code or, if it is empty, the recovery code
+// is valid for the user with two-factor authentication enabled. Both kinds of codes are
+// accepted only once.
+func (app *application) verifySecondFactor(userID int64, code, recoveryCode string) (bool, error) {
+	// Get the TOTP settings of the user.
+	settings, err := app.models.TOTP.Get(userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+	if !settings.Enabled {
+		return false, nil
+	}
+
+	// Use up the recovery code.
+	if recoveryCode != "" {
+		err = app.models.TOTP.UseRecoveryCode(userID, recoveryCode)
+		switch {
+		case err == nil:
+			return true, nil
+		case errors.Is(err, data.ErrRecordNotFound):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	// Check the TOTP code and reject the replay of an used code.
+	step, ok, err := totp.Validate(settings.Secret, code, time.Now())
+	if err != nil || !ok {
+		return false, err
+	}
+	err = app.models.TOTP.UseStep(userID, step)
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, data.ErrCodeReused):
+		return false, nil
+	default:
+		return false, err
+	}
+}
@@ -33,68 +33,113 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
-// rateLimit is a middlerware that wraps the handler next with a map of ip-based rate limiter.
-// It also automatically deletes limiters of clients that are not seen for a long time.
-func (app *application) rateLimit(next http.Handler) http.Handler {
-	// client stores the limiter of a client and the last seem time of it.
-	type client struct {
-		limiter  *rate.Limiter
-		lastSeen time.Time
-	}
+// ipRateLimiter holds the rate limiters of clients by ip. It also automatically deletes
+// limiters of clients that are not seen for a long time.
+type ipRateLimiter struct {
+	mu      sync.Mutex
+	clients map[string]*rateLimitClient
+	rps     float64 // rate limit per second of each client
+	burst   int
+}
 
-	// Create a map of rate limiters.
-	var (
-		mu        sync.Mutex
-		clients   = make(map[string]*client)
-		stayLimit = 3 * time.Minute
-	)
+// rateLimitClient stores the limiter of a client and the last seem time of it.
+type rateLimitClient struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// newIPRateLimiter returns an ipRateLimiter allowing rps requests per second with given burst to each client.
+func newIPRateLimiter(rps float64, burst int) *ipRateLimiter {
+	l := &ipRateLimiter{
+		clients: make(map[string]*rateLimitClient),
+		rps:     rps,
+		burst:   burst,
+	}
 
 	// Remove the limiters of clients that are not seen for a long time every minute.
+	stayLimit := 3 * time.Minute
 	go func() {
 		for {
 			time.Sleep(time.Minute)
 
-			mu.Lock()
+			l.mu.Lock()
 
 			// Remove limiters.
-			for ip, c := range clients {
+			for ip, c := range l.clients {
 				if time.Since(c.lastSeen) > stayLimit {
-					delete(clients, ip)
+					delete(l.clients, ip)
 				}
 			}
 
-			mu.Unlock()
+			l.mu.Unlock()
 		}
 	}()
 
+	return l
+}
+
+// allow takes a token from the limiter of the client with given ip, and reports whether it is available.
+func (l *ipRateLimiter) allow(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Get the limiter of this ip.
+	// If this ip is not in clients, then create a limiter for it.
+	if _, ok := l.clients[ip]; !ok {
+		l.clients[ip] = &rateLimitClient{
+			limiter: rate.NewLimiter(rate.Limit(l.rps), l.burst),
+		}
+	}
+	// Update lastSeen of this client.
+	l.clients[ip].lastSeen = time.Now()
+
+	// Take a token.
+	return l.clients[ip].limiter.Allow()
+}
+
+// rateLimit is a middlerware that wraps the handler next with ip-based rate limiters.
+// Movie suggestions are skipped if they are limited by suggestRateLimit instead.
+func (app *application) rateLimit(next http.Handler) http.Handler {
+	// Create the rate limiters.
+	limiter := newIPRateLimiter(app.config.limiter.rps, app.config.limiter.burst)
+
 	// Wrap next with limiter.
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Extract the ip from the request r.
-		ip := realip.FromRequest(r)
-		mu.Lock()
-		// Get the limiter of this ip.
-		// If this ip is not in clients, then create a limiter for it.
-		if _, ok := clients[ip]; !ok {
-			clients[ip] = &client{
-				limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst),
-			}
+		// Skip the suggestions with their own limiter, since autocomplete sends a request for each keystroke.
+		if app.suggestLimiter != nil && isMovieSuggestionRequest(r) {
+			next.ServeHTTP(w, r)
+			return
 		}
-		// Update lastSeen of this client.
-		clients[ip].lastSeen = time.Now()
 
 		// Take a token. If no token is available, drop this request and inform the client.
-		if !clients[ip].limiter.Allow() {
+		if !limiter.allow(realip.FromRequest(r)) {
 			app.rateLimitExceededResponse(w, r)
-			mu.Unlock()
 			return
 		}
 
-		mu.Unlock()
-
 		next.ServeHTTP(w, r)
 	})
 }
 
+// suggestRateLimit is a middleware that wraps the handler of movie suggestions next with
+// app.suggestLimiter, which is separate from the global rate limiter. It returns next
+// unchanged if the rate limiter of suggestions is disabled.
+func (app *application) suggestRateLimit(next http.HandlerFunc) http.HandlerFunc {
+	if app.suggestLimiter == nil {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Take a token. If no token is available, drop this request and inform the client.
+		if !app.suggestLimiter.allow(realip.FromRequest(r)) {
+			app.rateLimitExceededResponse(w, r)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
 // authenticate is a middleware that authenticates the user in the request.
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -113,14 +158,47 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 
 		// Varify the authorization token.
 		authTokenParts := strings.Split(authorizationHeader, " ")
-		if len(authTokenParts) != 2 || authTokenParts[0] != "Bearer" {
+		if len(authTokenParts) != 2 || (authTokenParts[0] != "Bearer" && authTokenParts[0] != "ApiKey") {
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
 		}
 
+		// Authenticate with the API key.
+		if authTokenParts[0] == "ApiKey" {
+			app.authenticateAPIKey(w, r, next, authTokenParts[1])
+			return
+		}
+
 		// Extract the token.
 		token := authTokenParts[1]
 
+		// Verify the signed token without hitting DB.
+		if isSignedToken(token) {
+			claims, err := app.verifySignedToken(token)
+			if err != nil {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+			userID, err := claims.userID()
+			if err != nil {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+
+			// The user only holds the information carried by the token. Handlers which
+			// need other information of the user should load the user from DB.
+			user := &data.User{ID: userID, Activated: claims.Activated}
+
+			// Put user, permissions and token into the request context.
+			r = app.contextSetUser(r, user)
+			r = app.contextSetPermissions(r, claims.Permissions)
+			r = app.contextSetToken(r, token)
+
+			// Call the next handler.
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		// Validate the token.
 		v := validator.New()
 		if data.ValidateTokenPlaintext(v, token); !v.Valid() {
@@ -140,14 +218,76 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		// Put user into the request context.
+		// Record the usage of this token.
+		err = app.models.Tokens.UpdateLastUsed(token)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// Put user and token into the request context.
 		r = app.contextSetUser(r, user)
+		r = app.contextSetToken(r, token)
 
 		// Call the next handler.
 		next.ServeHTTP(w, r)
 	})
 }
 
+// authenticateAPIKey authenticates the request r with the API key, and calls next with the
+// user of the key and the permissions of the user allowed by the key in the request context.
+func (app *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, plaintext string) {
+	// Validate the key.
+	v := validator.New()
+	if data.ValidateAPIKeyPlaintext(v, plaintext); !v.Valid() {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	// Get the key and its user from DB.
+	key, err := app.models.APIKeys.GetForPlaintext(plaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	user, err := app.models.Users.Get(key.UserID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Limit the permissions of the user to the ones allowed by the key. Permissions
+	// revoked from the user are revoked from the key as well.
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if key.Permissions != nil {
+		permissions = permissions.Intersect(key.Permissions)
+	}
+
+	// Record the usage of this key.
+	err = app.models.APIKeys.UpdateLastUsed(key.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Put user, permissions and key into the request context.
+	r = app.contextSetUser(r, user)
+	r = app.contextSetPermissions(r, permissions)
+	r = app.contextSetToken(r, plaintext)
+
+	// Call the next handler.
+	next.ServeHTTP(w, r)
+}
+
 // requireActivatedUser is a middleware that check if the user is authenticated and activated.
 func (app *application) requireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
 	f := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -184,17 +324,57 @@ func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.Han
 	})
 }
 
+// requireSession is a middleware that checks if the user is authenticated with a token of
+// a login session rather than an API key, so that API keys cannot be used to manage the
+// credentials, the sessions and the account of the user.
+func (app *application) requireSession(next http.HandlerFunc) http.HandlerFunc {
+	f := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Check if the user is authenticated with an API key.
+		if data.IsAPIKey(app.contextGetToken(r)) {
+			app.apiKeyNotAllowedResponse(w, r)
+			return
+		}
+
+		// Call the next handler.
+		next.ServeHTTP(w, r)
+	})
+
+	return app.requireAuthenticatedUser(f)
+}
+
+// requireSessionUser is a middleware that checks if the user is activated and authenticated
+// with a token of a login session rather than an API key.
+func (app *application) requireSessionUser(next http.HandlerFunc) http.HandlerFunc {
+	f := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Check if the user account is activated.
+		if !app.contextGetUser(r).Activated {
+			app.invalidAccountResponse(w, r)
+			return
+		}
+
+		// Call the next handler.
+		next.ServeHTTP(w, r)
+	})
+
+	return app.requireSession(f)
+}
+
 // requirePermission is a middlerware that check if the user has permission of the permission code.
 func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
 	f := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Get the user.
 		user := app.contextGetUser(r)
 
-		// Get the permissions of this user.
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
-		if err != nil {
-			app.serverErrorResponse(w, r, err)
-			return
+		// Get the permissions of this user. Use the permissions carried by the
+		// authentication token if there are, otherwise get them from DB.
+		permissions, ok := app.contextGetPermissions(r)
+		if !ok {
+			var err error
+			permissions, err = app.models.Permissions.GetAllForUser(user.ID)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
 		}
 
 		// Check if the permissions of this users has the given permission code.
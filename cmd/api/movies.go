@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"strconv"
 
+	"greenlight.kerseeehuang.com/internal/audit"
 	"greenlight.kerseeehuang.com/internal/data"
 	"greenlight.kerseeehuang.com/internal/validator"
 )
@@ -15,10 +16,11 @@ import (
 func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Request) {
 	// Declare an anonymous struct to holding decoded input.
 	var input struct {
-		Title   string       `json:"title"`
-		Year    int32        `json:"year"`
-		Runtime data.Runtime `json:"runtime"`
-		Genres  []string     `json:"genres"`
+		Title    string       `json:"title"`
+		Year     int32        `json:"year"`
+		Runtime  data.Runtime `json:"runtime"`
+		Genres   []string     `json:"genres"`
+		Language string       `json:"language"`
 	}
 
 	// Decode the movie information from the request.
@@ -30,10 +32,16 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 
 	// Copy the values from input into movie.
 	movie := &data.Movie{
-		Title:   input.Title,
-		Year:    input.Year,
-		Runtime: input.Runtime,
-		Genres:  input.Genres,
+		Title:    input.Title,
+		Year:     input.Year,
+		Runtime:  input.Runtime,
+		Genres:   input.Genres,
+		Language: input.Language,
+	}
+
+	// Use no stemming if the language is not given.
+	if movie.Language == "" {
+		movie.Language = "simple"
 	}
 
 	// Validation the movie
@@ -45,12 +53,15 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 
 	// Insert the movie into DB, and update this movie struct instance
 	// with system generated information.
-	err = app.models.Movies.Insert(movie)
+	err = app.models.Movies.Insert(movie, app.contextGetUser(r).ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	// Record the creation.
+	app.recordAuditEvent(r, audit.Event{Action: audit.ActionMovieCreate, TargetType: audit.TargetMovie, TargetID: movie.ID, After: movie})
+
 	// Create the headers and set location header
 	// to inform customers the location of their newly created movie.
 	headers := make(http.Header)
@@ -98,6 +109,7 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	id, err := app.readIDParam(r)
 	if err != nil {
 		app.notFoundResponse(w, r)
+		return
 	}
 
 	// Fetch the current movie information with given movie id.
@@ -114,7 +126,7 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	// Check the expected version first if it is provided.
-	if expVer := r.Header.Get("X-Expected-Version"); expVer != "" && strconv.FormatInt(int64(movie.Version), 32) != expVer {
+	if expVer := r.Header.Get("X-Expected-Version"); expVer != "" && strconv.FormatInt(int64(movie.Version), 10) != expVer {
 		app.editConflictResponse(w, r)
 		return
 	}
@@ -122,10 +134,11 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	// Declare an anonymous struct to holding decoded input.
 	// Use pointer to detect whether keys in input are given or not.
 	var input struct {
-		Title   *string       `json:"title"`
-		Year    *int32        `json:"year"`
-		Runtime *data.Runtime `json:"runtime"`
-		Genres  []string      `json:"genres"`
+		Title    *string       `json:"title"`
+		Year     *int32        `json:"year"`
+		Runtime  *data.Runtime `json:"runtime"`
+		Genres   []string      `json:"genres"`
+		Language *string       `json:"language"`
 	}
 
 	// Decode the movie information from the request.
@@ -135,6 +148,9 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// Keep the current movie for the audit log.
+	before := *movie
+
 	// Copy the values from input into movie.
 	if input.Title != nil {
 		movie.Title = *input.Title
@@ -148,6 +164,9 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	if input.Genres != nil {
 		movie.Genres = input.Genres
 	}
+	if input.Language != nil {
+		movie.Language = *input.Language
+	}
 
 	// Validation the movie
 	v := validator.New()
@@ -157,7 +176,7 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	// Update the information of this movie in DB.
-	err = app.models.Movies.Update(movie)
+	err = app.models.Movies.Update(movie, app.contextGetUser(r).ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -168,6 +187,9 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// Record the changes.
+	app.recordAuditEvent(r, audit.Event{Action: audit.ActionMovieUpdate, TargetType: audit.TargetMovie, TargetID: movie.ID, Before: before, After: movie})
+
 	// Write the updated movie to the response.
 	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
 	if err != nil {
@@ -175,7 +197,7 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 }
 
-// deleteMovieHandler delete the movie from DB with given id in the request.
+// deleteMovieHandler moves the movie with given id in the request to trash.
 func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Request) {
 	// Read the movie id in the request.
 	id, err := app.readIDParam(r)
@@ -184,7 +206,19 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Delete the movie from DB.
+	// Fetch the movie from DB for the audit log.
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Move the movie to trash.
 	err = app.models.Movies.Delete(id)
 	if err != nil {
 		switch {
@@ -196,8 +230,11 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// Record the deletion.
+	app.recordAuditEvent(r, audit.Event{Action: audit.ActionMovieDelete, TargetType: audit.TargetMovie, TargetID: id, Before: movie})
+
 	// Write the statusOK to the response.
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie succesfully deleted"}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully moved to trash"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -206,9 +243,9 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 // listMoviesHandler lists the movie with given query in r.URL.Values.
 func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title  string
-		Genres []string
+		data.MovieFilter
 		data.Filters
+		Facets []string
 	}
 
 	// Prepare a validator.
@@ -220,26 +257,86 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	// Populate the input struct.
 	input.Title = app.readString(qs, "title", "")
 	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.GenresMatch = app.readString(qs, "genres_match", data.GenresMatchAll)
+	input.ExcludeGenres = app.readCSV(qs, "exclude_genres", []string{})
+	input.YearMin = app.readInt(qs, "year_min", 0, v)
+	input.YearMax = app.readInt(qs, "year_max", 0, v)
+	input.RuntimeMin = app.readInt(qs, "runtime_min", 0, v)
+	input.RuntimeMax = app.readInt(qs, "runtime_max", 0, v)
+	input.CreatedSince = app.readTime(qs, "created_since", v)
+	input.Search = app.readString(qs, "q", "")
+	if prefix := app.readBool(qs, "prefix", nil, v); prefix != nil {
+		input.Prefix = *prefix
+	}
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
-	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
+	input.Facets = app.readCSV(qs, "facets", []string{})
+
+	// Sort the search results by relevance by default. The rank is only defined for searches.
 	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	if input.Search == "" {
+		input.Filters.Sort = app.readString(qs, "sort", "id")
+	} else {
+		input.Filters.Sort = app.readString(qs, "sort", "-rank")
+		input.Filters.SortSafelist = append(input.Filters.SortSafelist, "rank", "-rank")
+	}
 
+	data.ValidateMovieFilter(v, input.MovieFilter)
+	data.ValidateFacets(v, input.Facets)
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
 	// Get all results from DB based on given input.
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	movies, metadata, err := app.models.Movies.GetAll(input.MovieFilter, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+		return
 	}
 
-	// Write the movies to response.
+	// Prepare the response with the movies.
 	data := envelope{"metadata": metadata, "movies": movies}
+
+	// Count the facets of the matching movies if they are requested.
+	if len(input.Facets) > 0 {
+		facets, err := app.models.Movies.Facets(input.MovieFilter, input.Facets)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		data["facets"] = facets
+	}
+
+	// Write the movies to response.
 	err = app.writeJSON(w, http.StatusOK, data, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// readMovieParam retrieves the movie with the id in the URL from DB.
+// If the movie cannot be retrieved, it sends the error response and returns false.
+func (app *application) readMovieParam(w http.ResponseWriter, r *http.Request) (*data.Movie, bool) {
+	// Read the movie id in the request.
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	// Fetch the movie from DB with given id.
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return movie, true
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"greenlight.kerseeehuang.com/internal/data"
+	"greenlight.kerseeehuang.com/internal/oidc"
+	"greenlight.kerseeehuang.com/internal/validator"
+)
+
+// setupOIDC discovers the OpenID Connect provider and sets app.oidc if OpenID Connect login is enabled.
+func (app *application) setupOIDC() error {
+	if app.config.oidc.issuer == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	provider, err := oidc.Discover(ctx, oidc.Config{
+		Issuer:       app.config.oidc.issuer,
+		ClientID:     app.config.oidc.clientID,
+		ClientSecret: app.config.oidc.clientSecret,
+		RedirectURL:  app.config.oidc.redirectURL,
+		Scopes:       []string{"email", "profile"},
+	})
+	if err != nil {
+		return err
+	}
+	app.oidc = provider
+
+	return nil
+}
+
+// oidcLoginHandler starts an OpenID Connect login by redirecting the client to the
+// authorization endpoint of the provider.
+func (app *application) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	// Generate the state, the nonce and the PKCE code verifier of this login.
+	var values [3]string
+	for i := range values {
+		value, err := oidc.NewState()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		values[i] = value
+	}
+	state := &data.OIDCState{
+		State:        values[0],
+		Nonce:        values[1],
+		CodeVerifier: values[2],
+		Expiry:       time.Now().Add(app.config.oidc.loginTTL),
+	}
+
+	// Store them until the provider redirects the client back.
+	err := app.models.OIDCStates.Insert(state)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Bind the state to the browser of the client, so that the callback of another login
+	// cannot be sent to this browser.
+	http.SetCookie(w, app.oidcStateCookie(stateCookieValue(state.State), app.config.oidc.loginTTL))
+
+	// Redirect the client to the provider.
+	http.Redirect(w, r, app.oidc.AuthCodeURL(state.State, state.Nonce, state.CodeVerifier), http.StatusFound)
+}
+
+// oidcCallbackHandler finishes an OpenID Connect login by exchanging the authorization code
+// for the ID token of the user. The user linked to the identity in the ID token is logged in.
+// If no user is linked yet, the user with the verified email of the identity is linked, or a
+// new activated user is created.
+func (app *application) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the query.
+	qs := r.URL.Query()
+
+	// Refuse the login if the provider reports an error.
+	if errorCode := qs.Get("error"); errorCode != "" {
+		app.logger.PrintInfo("openid connect login refused by provider", map[string]string{
+			"error":       errorCode,
+			"description": qs.Get("error_description"),
+		})
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	// Validate the query.
+	code, stateValue := qs.Get("code"), qs.Get("state")
+	v := validator.New()
+	v.Check(code != "", "code", validator.ErrMsgMustBeProvided)
+	v.Check(stateValue != "", "state", validator.ErrMsgMustBeProvided)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Check that the login was started by this browser, and clear the cookie of the state.
+	cookie, err := r.Cookie(oidcStateCookieName)
+	http.SetCookie(w, app.oidcStateCookie("", -1))
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(stateCookieValue(stateValue))) != 1 {
+		v.AddError("state", "does not match the login started by this browser")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Consume the state of this login.
+	state, err := app.models.OIDCStates.Consume(stateValue)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("state", "invalid or expired")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Exchange the code for the verified claims of the ID token.
+	claims, err := app.oidc.Exchange(r.Context(), code, state.CodeVerifier, state.Nonce)
+	if err != nil {
+		switch {
+		case errors.Is(err, oidc.ErrInvalidIDToken), errors.Is(err, oidc.ErrExchangeFailed):
+			app.logger.PrintInfo(err.Error(), nil)
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Get the user of this identity.
+	user, err := app.oidcUser(claims)
+	if err != nil {
+		switch {
+		case errors.Is(err, errUnverifiedEmail):
+			v.AddError("email", "must be verified by the identity provider")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Issue the tokens, or ask for the second factor if the user has enabled it.
+	app.completeLogin(w, r, user)
+}
+
+// oidcStateCookieName is the name of the cookie binding the state of an OpenID Connect
+// login to the browser which started it.
+const oidcStateCookieName = "greenlight_oidc_state"
+
+// stateCookieValue returns the value of the state cookie of state, which is the hash of
+// state, so that the state itself is only known to the provider and DB.
+func stateCookieValue(state string) string {
+	hash := sha256.Sum256([]byte(state))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// oidcStateCookie returns the state cookie with value, which is sent to the callback only
+// and expires after maxAge. A negative maxAge deletes the cookie.
+func (app *application) oidcStateCookie(value string, maxAge time.Duration) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     oidcStateCookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(maxAge.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if maxAge < 0 {
+		cookie.MaxAge = -1
+	}
+
+	// Limit the cookie to the callback, and to HTTPS if the callback uses it.
+	if callback, err := url.Parse(app.config.oidc.redirectURL); err == nil {
+		if callback.Path != "" {
+			cookie.Path = callback.Path
+		}
+		cookie.Secure = strings.EqualFold(callback.Scheme, "https")
+	}
+
+	return cookie
+}
+
+// errUnverifiedEmail is returned by oidcUser if an unlinked identity has no verified email.
+var errUnverifiedEmail = errors.New("unverified email")
+
+// oidcUser returns the user linked to the identity with claims, and links or creates the
+// user if the identity is not linked yet.
+func (app *application) oidcUser(claims *oidc.Claims) (*data.User, error) {
+	provider := app.config.oidc.issuer
+
+	// Get the linked user.
+	userID, err := app.models.Identities.GetUserID(provider, claims.Subject)
+	switch {
+	case err == nil:
+		return app.models.Users.Get(userID)
+	case !errors.Is(err, data.ErrRecordNotFound):
+		return nil, err
+	}
+
+	// Only a verified email proves that the identity owns the email address.
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, errUnverifiedEmail
+	}
+
+	// Get the user with the email, or create a new user.
+	user, err := app.models.Users.GetByEmail(claims.Email)
+	switch {
+	case err == nil:
+		// The provider has verified the email, hence the user is activated.
+		if !user.Activated {
+			err = app.claimOIDCUser(user)
+			if err != nil {
+				return nil, err
+			}
+		}
+	case errors.Is(err, data.ErrRecordNotFound):
+		user, err = app.createOIDCUser(claims)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	// Link the identity to the user.
+	err = app.models.Identities.Insert(provider, claims.Subject, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// claimOIDCUser activates the unactivated user with the email verified by the identity provider.
+// Anyone may have registered the user with the email, hence the password, the pending email,
+// the tokens and the API keys of the user are discarded, so that they cannot access the account.
+func (app *application) claimOIDCUser(user *data.User) error {
+	// Revoke all sessions and API keys of this user.
+	err := app.revokeAllSessions(user.ID)
+	if err != nil {
+		return err
+	}
+
+	// Delete the other tokens of this user.
+	for _, scope := range []string{data.ScopeActivation, data.ScopePasswordReset, data.ScopeEmailChange, data.ScopeMagicLink} {
+		err = app.models.Tokens.DeleteAllForUser(scope, user.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Replace the password, clear the pending email and activate this user.
+	err = setRandomPassword(user)
+	if err != nil {
+		return err
+	}
+	user.PendingEmail = nil
+	user.Activated = true
+
+	return app.models.Users.Update(user)
+}
+
+// setRandomPassword sets a random password of user, which the user does not know.
+func setRandomPassword(user *data.User) error {
+	password, err := oidc.NewState()
+	if err != nil {
+		return err
+	}
+	return user.Password.Set(password)
+}
+
+// createOIDCUser creates an activated user with the email and name of the identity with claims.
+// The password of the user is random, since the user logs in with the identity provider.
+func (app *application) createOIDCUser(claims *oidc.Claims) (*data.User, error) {
+	user := &data.User{
+		Name:      claims.Name,
+		Email:     claims.Email,
+		Activated: true,
+	}
+	if user.Name == "" || len(user.Name) > 500 {
+		user.Name = claims.Email
+	}
+
+	// Set a random password.
+	err := setRandomPassword(user)
+	if err != nil {
+		return nil, err
+	}
+
+	// Insert the user into DB.
+	err = app.models.Users.Insert(user)
+	if err != nil {
+		return nil, err
+	}
+
+	// Assign the viewer role, which permits reading movies, to this user.
+	err = app.models.Roles.AddForUser(user.ID, data.RoleViewer)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"greenlight.kerseeehuang.com/internal/audit"
+	"greenlight.kerseeehuang.com/internal/data"
+	"greenlight.kerseeehuang.com/internal/validator"
+)
+
+// listMovieRevisionsHandler lists the revisions of the movie with the id in the URL.
+func (app *application) listMovieRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the movie.
+	movie, ok := app.readMovieParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+
+	// Prepare a validator.
+	v := validator.New()
+
+	// Get the query.
+	qs := r.URL.Query()
+
+	// Populate the input struct.
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-version")
+	input.Filters.SortSafelist = []string{"version", "created_at", "-version", "-created_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Get the revisions of this movie.
+	revisions, metadata, err := app.models.MovieRevisions.GetAll(movie.ID, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Write the revisions to response.
+	err = app.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "revisions": revisions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showMovieRevisionHandler shows the revision with the version in the URL of the movie with the id in the URL.
+func (app *application) showMovieRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the movie, so that the revisions of trashed movies are not found.
+	movie, ok := app.readMovieParam(w, r)
+	if !ok {
+		return
+	}
+
+	// Read the version in the request.
+	version, err := strconv.ParseInt(app.readStringParam(r, "version"), 10, 32)
+	if err != nil || version < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// Fetch the revision from DB.
+	revision, err := app.models.MovieRevisions.Get(movie.ID, int32(version))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Write the revision to response.
+	err = app.writeJSON(w, http.StatusOK, envelope{"revision": revision}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revertMovieHandler restores the content of an older version of the movie with the id in
+// the URL. The restored content is stored as a new version, so that the history is kept.
+func (app *application) revertMovieHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the movie.
+	movie, ok := app.readMovieParam(w, r)
+	if !ok {
+		return
+	}
+
+	// Check the expected version first if it is provided.
+	if expVer := r.Header.Get("X-Expected-Version"); expVer != "" && strconv.FormatInt(int64(movie.Version), 10) != expVer {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	// Parse the input.
+	var input struct {
+		Version int32 `json:"version"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Validate the version.
+	v := validator.New()
+	v.Check(input.Version != 0, "version", validator.ErrMsgMustBeProvided)
+	v.Check(input.Version > 0, "version", "must be a positive integer")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Get the revision to restore.
+	revision, err := app.models.MovieRevisions.Get(movie.ID, input.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("version", "no such version of the movie")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Keep the current movie for the audit log.
+	before := *movie
+
+	// Copy the content of the revision into movie.
+	movie.Title = revision.Title
+	movie.Year = revision.Year
+	movie.Runtime = revision.Runtime
+	movie.Genres = revision.Genres
+	movie.Language = revision.Language
+
+	// Store the restored content as a new version.
+	err = app.models.Movies.Update(movie, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Record the changes.
+	app.recordAuditEvent(r, audit.Event{Action: audit.ActionMovieRevert, TargetType: audit.TargetMovie, TargetID: movie.ID, Before: before, After: movie})
+
+	// Write the reverted movie to the response.
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
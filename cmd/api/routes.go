@@ -24,14 +24,62 @@ func (app *application) routes() http.Handler {
 
 	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission(data.PermissionReadMovies, app.listMoviesHandler))
 	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission(data.PermissionWriteMovies, app.createMovieHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission(data.PermissionReadMovies, app.showMovieHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.dispatchParam("id", map[string]http.HandlerFunc{
+		"trash":   app.requirePermission(data.PermissionWriteMovies, app.listTrashedMoviesHandler),
+		"suggest": app.suggestRateLimit(app.requirePermission(data.PermissionReadMovies, app.suggestMoviesHandler)),
+	}, app.requirePermission(data.PermissionReadMovies, app.showMovieHandler)))
 	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission(data.PermissionWriteMovies, app.updateMovieHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission(data.PermissionWriteMovies, app.deleteMovieHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/revisions", app.requirePermission(data.PermissionReadMovies, app.listMovieRevisionsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/revisions/:version", app.requirePermission(data.PermissionReadMovies, app.showMovieRevisionHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/revert", app.requirePermission(data.PermissionWriteMovies, app.revertMovieHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/restore", app.requirePermission(data.PermissionWriteMovies, app.restoreMovieHandler))
 
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/email", app.confirmUserEmailHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/users/me", app.requireAuthenticatedUser(app.showCurrentUserHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me", app.requireAuthenticatedUser(app.updateCurrentUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me", app.requireSession(app.deleteCurrentUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/deletion", app.requireSession(app.cancelCurrentUserDeletionHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/export", app.requireSession(app.exportCurrentUserHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/password", app.requireSession(app.updateCurrentUserPasswordHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me/email", app.requireSessionUser(app.updateCurrentUserEmailHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/mfa", app.requireSessionUser(app.createMFAEnrollmentHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/mfa", app.requireSessionUser(app.confirmMFAEnrollmentHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/mfa", app.requireSessionUser(app.deleteMFAHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/api-keys", app.requireSessionUser(app.listAPIKeysHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/api-keys", app.requireSessionUser(app.createAPIKeyHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/api-keys/:id", app.requireSessionUser(app.deleteAPIKeyHandler))
 
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/tokens/authentication", app.requireSession(app.listAuthenticationTokensHandler))
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication", app.requireSession(app.deleteAuthenticationTokenHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication/all", app.requireSession(app.deleteAllAuthenticationTokensHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication/magic-link", app.createMagicLinkAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/magic-link", app.createMagicLinkTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/mfa", app.createMFAAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/refresh", app.refreshAuthenticationTokenHandler)
+
+	if app.oidc != nil {
+		router.HandlerFunc(http.MethodGet, "/v1/oidc/login", app.oidcLoginHandler)
+		router.HandlerFunc(http.MethodGet, "/v1/oidc/callback", app.oidcCallbackHandler)
+	}
+
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users", app.requirePermission(data.PermissionAdminUsers, app.listUsersHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id", app.requirePermission(data.PermissionAdminUsers, app.showUserHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/users/:id/activated", app.requirePermission(data.PermissionAdminUsers, app.updateUserActivationHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/permissions", app.requirePermission(data.PermissionAdminUsers, app.grantUserPermissionHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/permissions/:code", app.requirePermission(data.PermissionAdminUsers, app.revokeUserPermissionHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/roles", app.requirePermission(data.PermissionAdminUsers, app.assignUserRoleHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/roles/:role", app.requirePermission(data.PermissionAdminUsers, app.unassignUserRoleHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/tokens", app.requirePermission(data.PermissionAdminUsers, app.deleteUserTokensHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/lockout", app.requirePermission(data.PermissionAdminUsers, app.unlockUserHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/audit", app.requirePermission(data.PermissionAdminUsers, app.listAuditEventsHandler))
 
 	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
 
@@ -44,3 +92,16 @@ func (app *application) routes() http.Handler {
 
 	return chain.Then(router)
 }
+
+// dispatchParam routes the requests whose URL parameter name has a value in static to the
+// handlers of the values, and the other requests to next. The router does not allow a
+// static segment next to a parameter, such as "/v1/movies/trash" next to "/v1/movies/:id".
+func (app *application) dispatchParam(name string, static map[string]http.HandlerFunc, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if handler, ok := static[app.readStringParam(r, name)]; ok {
+			handler(w, r)
+			return
+		}
+		next(w, r)
+	}
+}
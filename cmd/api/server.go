@@ -55,7 +55,8 @@ func (app *application) serve() error {
 			"addr": srv.Addr,
 		})
 
-		// Wait for all background tasks completion.
+		// Stop the periodic jobs and wait for all background tasks completion.
+		close(app.shutdown)
 		app.wg.Wait()
 		shutdownError <- nil
 	}()
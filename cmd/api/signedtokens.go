@@ -0,0 +1,356 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"greenlight.kerseeehuang.com/internal/data"
+	"greenlight.kerseeehuang.com/internal/jwt"
+)
+
+// Modes of authentication tokens.
+const (
+	authTokenModeDB     = "db"     // authentication tokens are random strings stored in DB
+	authTokenModeSigned = "signed" // authentication tokens are signed tokens verified without DB
+)
+
+// accessClaims holds the claims of a signed authentication token.
+type accessClaims struct {
+	ID          string           `json:"jti"`
+	Subject     string           `json:"sub"` // user ID
+	IssuedAt    int64            `json:"iat"`
+	IssuedAtUs  int64            `json:"iat_us,omitempty"` // issued time in microseconds, compared with the revocation time
+	Expiry      int64            `json:"exp"`
+	Activated   bool             `json:"activated"`
+	Permissions data.Permissions `json:"permissions"`
+	Family      string           `json:"family,omitempty"` // base64-encoded token family of the refresh tokens
+}
+
+// issuedAt returns the issued time of c in microseconds. Tokens issued before the
+// iat_us claim was added only have the issued time in seconds.
+func (c *accessClaims) issuedAt() int64 {
+	if c.IssuedAtUs != 0 {
+		return c.IssuedAtUs
+	}
+	return c.IssuedAt * int64(time.Second/time.Microsecond)
+}
+
+// userID returns the user ID in the subject of c.
+func (c *accessClaims) userID() (int64, error) {
+	return strconv.ParseInt(c.Subject, 10, 64)
+}
+
+// isSignedToken reports whether token looks like a signed token rather than a DB-backed token.
+func isSignedToken(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// setupSignedTokens creates app.signer and app.denyList if the token mode is "signed",
+// loads the deny-list from DB and starts syncing the deny-list periodically.
+func (app *application) setupSignedTokens() error {
+	switch app.config.auth.tokenMode {
+	case authTokenModeDB:
+		return nil
+	case authTokenModeSigned:
+	default:
+		return fmt.Errorf("invalid authentication token mode %q", app.config.auth.tokenMode)
+	}
+
+	// Create the keys in a stable order.
+	ids := make([]string, 0, len(app.config.auth.signingKeys))
+	for id := range app.config.auth.signingKeys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	if len(ids) == 0 {
+		return errors.New("signed authentication tokens require at least one signing key")
+	}
+
+	keys := make([]*jwt.Key, 0, len(ids))
+	for _, id := range ids {
+		var key *jwt.Key
+		var err error
+		switch app.config.auth.signingAlg {
+		case jwt.AlgorithmHS256:
+			key, err = jwt.NewHMACKey(id, app.config.auth.signingKeys[id])
+		case jwt.AlgorithmEdDSA:
+			key, err = jwt.NewEd25519Key(id, app.config.auth.signingKeys[id])
+		default:
+			err = fmt.Errorf("invalid signing algorithm %q", app.config.auth.signingAlg)
+		}
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+
+	// Sign with the configured key, or with the only key if there is exactly one.
+	current := app.config.auth.signingKeyID
+	if current == "" && len(ids) == 1 {
+		current = ids[0]
+	}
+
+	signer, err := jwt.NewKeySet(current, keys...)
+	if err != nil {
+		return err
+	}
+	app.signer = signer
+
+	// Load the deny-list and keep it in sync with DB, since revocations may be
+	// made by other instances of the application.
+	app.denyList = newDenyList()
+	err = app.syncDenyList()
+	if err != nil {
+		return err
+	}
+	app.runPeriodically("sync deny-list", app.config.auth.denyListSync, app.syncDenyList)
+
+	return nil
+}
+
+// newSignedAuthenticationToken returns a signed authentication token of user with
+// the permissions of the user, which belongs to the token family of the refresh tokens.
+func (app *application) newSignedAuthenticationToken(user *data.User, permissions data.Permissions, family []byte) (*data.Token, error) {
+	// Generate a random token ID for revocation.
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	claims := accessClaims{
+		ID:          base64.RawURLEncoding.EncodeToString(id),
+		Subject:     strconv.FormatInt(user.ID, 10),
+		IssuedAt:    now.Unix(),
+		IssuedAtUs:  now.UnixMicro(),
+		Expiry:      now.Add(app.config.tokens.authenticationTTL).Unix(),
+		Activated:   user.Activated,
+		Permissions: permissions,
+	}
+	if family != nil {
+		claims.Family = base64.RawURLEncoding.EncodeToString(family)
+	}
+
+	// Sign the claims.
+	plaintext, err := app.signer.Sign(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &data.Token{
+		Plaintext: plaintext,
+		UserID:    user.ID,
+		CreatedAt: now,
+		Expiry:    time.Unix(claims.Expiry, 0),
+		Scope:     data.ScopeAuthentication,
+		Family:    family,
+	}, nil
+}
+
+// verifySignedToken verifies the signed token, and returns its claims if the token
+// is valid and not revoked. Otherwise it returns jwt.ErrInvalidToken or the
+// verification error.
+func (app *application) verifySignedToken(token string) (*accessClaims, error) {
+	if app.signer == nil {
+		return nil, jwt.ErrInvalidToken
+	}
+
+	var claims accessClaims
+	err := app.signer.Verify(token, &claims)
+	if err != nil {
+		return nil, err
+	}
+
+	if app.denyList.revoked(&claims) {
+		return nil, jwt.ErrInvalidToken
+	}
+
+	return &claims, nil
+}
+
+// revokeSignedToken adds the signed token with given claims to the deny-list.
+func (app *application) revokeSignedToken(claims *accessClaims) error {
+	revocation := &data.Revocation{
+		TokenID:   claims.ID,
+		RevokedAt: time.Now(),
+		Expiry:    time.Unix(claims.Expiry, 0),
+	}
+
+	err := app.models.Revocations.Insert(revocation)
+	if err != nil {
+		return err
+	}
+	app.denyList.add(revocation)
+
+	return nil
+}
+
+// revokeAllSessions revokes all authentication, refresh and pending mfa tokens and all API keys
+// of the user. API keys are revoked too, since a stolen session could have created them.
+// In "signed" token mode, all signed tokens of the user issued so far are added to the deny-list.
+func (app *application) revokeAllSessions(userID int64) error {
+	// Delete all DB-backed tokens of this user.
+	for _, scope := range []string{data.ScopeAuthentication, data.ScopeRefresh, data.ScopeMFA} {
+		err := app.models.Tokens.DeleteAllForUser(scope, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Delete all API keys of this user.
+	err := app.models.APIKeys.DeleteAllForUser(userID)
+	if err != nil {
+		return err
+	}
+
+	return app.revokeSignedTokensForUser(userID)
+}
+
+// revokeOtherSessions revokes all authentication and refresh tokens of the current user
+// except the ones of the session making request r, which must not be made with an API key.
+// In "signed" token mode, all signed tokens of the user issued so far are added to the
+// deny-list, including the current one, hence the current session has to refresh its token.
+func (app *application) revokeOtherSessions(r *http.Request) error {
+	user := app.contextGetUser(r)
+	plaintext := app.contextGetToken(r)
+
+	// Find the token, and the token family, of the current session.
+	var keep *data.Token
+	if isSignedToken(plaintext) {
+		claims, err := app.verifySignedToken(plaintext)
+		if err != nil {
+			return err
+		}
+		family, err := base64.RawURLEncoding.DecodeString(claims.Family)
+		if err != nil {
+			return err
+		}
+		keep = &data.Token{Family: family}
+	} else {
+		var err error
+		keep, err = app.models.Tokens.GetForPlaintext(data.ScopeAuthentication, plaintext)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Delete the DB-backed tokens of other sessions.
+	for _, scope := range []string{data.ScopeAuthentication, data.ScopeRefresh} {
+		err := app.models.Tokens.DeleteAllForUserExcept(scope, user.ID, keep)
+		if err != nil {
+			return err
+		}
+	}
+
+	return app.revokeSignedTokensForUser(user.ID)
+}
+
+// revokeSignedTokensForUser adds all signed tokens of the user issued so far to the deny-list,
+// so that the user has to refresh the token to get the latest permissions and activation
+// state in the claims. It does nothing in "db" token mode.
+func (app *application) revokeSignedTokensForUser(userID int64) error {
+	if app.signer == nil {
+		return nil
+	}
+
+	// Revoke the signed tokens of this user until all of them have expired. The time is
+	// truncated to the precision of DB, so that the deny-lists synced from DB agree with it.
+	now := time.Now().Truncate(time.Microsecond)
+	revocation := &data.Revocation{
+		UserID:    userID,
+		RevokedAt: now,
+		Expiry:    now.Add(app.config.tokens.authenticationTTL),
+	}
+
+	err := app.models.Revocations.Insert(revocation)
+	if err != nil {
+		return err
+	}
+	app.denyList.add(revocation)
+
+	return nil
+}
+
+// syncDenyList replaces the deny-list with the unexpired revocations in DB,
+// and deletes the expired revocations from DB.
+func (app *application) syncDenyList() error {
+	err := app.models.Revocations.DeleteExpired()
+	if err != nil {
+		return err
+	}
+
+	revocations, err := app.models.Revocations.GetAllActive()
+	if err != nil {
+		return err
+	}
+	app.denyList.replace(revocations)
+
+	return nil
+}
+
+// denyList is an in-memory copy of the revocations of signed tokens, so that
+// signed tokens can be verified without hitting DB.
+type denyList struct {
+	mu     sync.RWMutex
+	tokens map[string]time.Time // expiry of the revocation by token ID
+	users  map[int64]time.Time  // revocation time of all tokens by user ID
+}
+
+// newDenyList returns an empty denyList.
+func newDenyList() *denyList {
+	return &denyList{
+		tokens: make(map[string]time.Time),
+		users:  make(map[int64]time.Time),
+	}
+}
+
+// add adds the revocation to l.
+func (l *denyList) add(revocation *data.Revocation) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if revocation.TokenID != "" {
+		l.tokens[revocation.TokenID] = revocation.Expiry
+	}
+	if revocation.UserID != 0 && revocation.RevokedAt.After(l.users[revocation.UserID]) {
+		l.users[revocation.UserID] = revocation.RevokedAt
+	}
+}
+
+// replace replaces all entries of l with revocations.
+func (l *denyList) replace(revocations []*data.Revocation) {
+	fresh := newDenyList()
+	for _, revocation := range revocations {
+		fresh.add(revocation)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens, l.users = fresh.tokens, fresh.users
+}
+
+// revoked reports whether the token with claims has been revoked.
+func (l *denyList) revoked(claims *accessClaims) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if _, ok := l.tokens[claims.ID]; ok {
+		return true
+	}
+
+	userID, err := claims.userID()
+	if err != nil {
+		return true
+	}
+	revokedAt, ok := l.users[userID]
+	return ok && claims.issuedAt() < revokedAt.UnixMicro()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+
+	"greenlight.kerseeehuang.com/internal/validator"
+)
+
+// isMovieSuggestionRequest reports whether r requests the movie suggestions.
+func isMovieSuggestionRequest(r *http.Request) bool {
+	return r.Method == http.MethodGet && r.URL.Path == "/v1/movies/suggest"
+}
+
+// suggestMoviesHandler suggests the movies whose titles start with or are similar to
+// the query q in r.URL.Values, for the autocomplete of titles.
+func (app *application) suggestMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Query string
+		Limit int
+	}
+
+	// Prepare a validator.
+	v := validator.New()
+
+	// Get the query.
+	qs := r.URL.Query()
+
+	// Populate the input struct.
+	input.Query = app.readString(qs, "q", "")
+	input.Limit = app.readInt(qs, "limit", 10, v)
+
+	// Validate the input.
+	v.Check(input.Query != "", "q", validator.ErrMsgMustBeProvided)
+	v.Check(len(input.Query) <= 100, "q", "must not be more than 100 bytes long")
+	v.Check(input.Limit > 0, "limit", "must be greater than zero")
+	v.Check(input.Limit <= 20, "limit", "must be a maximum of 20")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Get the suggestions from DB.
+	suggestions, err := app.models.Movies.Suggest(input.Query, input.Limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Write the suggestions to response.
+	err = app.writeJSON(w, http.StatusOK, envelope{"suggestions": suggestions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
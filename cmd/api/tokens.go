@@ -1,9 +1,14 @@
 package main
 
 import (
+	"encoding/base64"
 	"errors"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/tomasen/realip"
+	"greenlight.kerseeehuang.com/internal/audit"
 	"greenlight.kerseeehuang.com/internal/data"
 	"greenlight.kerseeehuang.com/internal/validator"
 )
@@ -31,12 +36,26 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
+	// Refuse the login attempt if there are too many failed logins.
+	retryAfter, err := app.loginRetryAfter(r, input.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if retryAfter > 0 {
+		app.loginThrottledResponse(w, r, retryAfter)
+		return
+	}
+
 	// Get the user by email.
 	user, err := app.models.Users.GetByEmail(input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			app.invalidCredentialsResponse(w, r)
+			// Take the same time and record the failure as for a wrong password,
+			// to avoid revealing whether the user exists.
+			data.SimulatePasswordMatch(input.Password)
+			app.failedLoginResponse(w, r, input.Email, nil)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -50,21 +69,592 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 	if !match {
-		app.invalidCredentialsResponse(w, r)
+		app.failedLoginResponse(w, r, input.Email, user)
+		return
+	}
+
+	// Clear the failed logins of this user.
+	err = app.models.Logins.ClearFailures(input.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Rehash the password if it was hashed with an outdated algorithm or parameters.
+	if user.Password.NeedsRehash() {
+		app.rehashPassword(user, input.Password)
+	}
+
+	// Issue the tokens, or ask for the second factor if the user has enabled it.
+	app.completeLogin(w, r, user)
+}
+
+// rehashPassword hashes the verified password of the user with the current password hasher
+// and stores it. Failures are only logged, since the login itself has succeeded.
+func (app *application) rehashPassword(user *data.User, plaintext string) {
+	err := user.Password.Set(plaintext)
+	if err == nil {
+		err = app.models.Users.Update(user)
+	}
+	// An edit conflict means the user has been updated concurrently, and the password
+	// is rehashed on a later login.
+	if err != nil && !errors.Is(err, data.ErrEditConflict) {
+		app.logger.PrintError(err.Error(), map[string]string{"user_id": strconv.FormatInt(user.ID, 10)})
+	}
+}
+
+// completeLogin finishes the login of the user whose first factor has been verified.
+// If the user has enabled two-factor authentication, it responds an intermediate "mfa"
+// token to be exchanged for the authentication tokens with a TOTP or recovery code.
+// Otherwise it responds a new pair of authentication and refresh token.
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, user *data.User) {
+	// Check if the user has enabled two-factor authentication.
+	settings, err := app.models.TOTP.Get(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if settings == nil || !settings.Enabled {
+		app.createAuthenticationTokensResponse(w, r, user.ID)
+		return
+	}
+
+	// Generate a new mfa token.
+	token, err := app.models.Tokens.NewForClient(user.ID, app.config.tokens.mfaTTL, data.ScopeMFA, realip.FromRequest(r), r.UserAgent())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Ask the client for the second factor.
+	env := envelope{"mfa_required": true, "mfa_token": token}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createAuthenticationTokensResponse starts a new token family for the login of the user,
+// and sends a new pair of authentication and refresh token to the client.
+func (app *application) createAuthenticationTokensResponse(w http.ResponseWriter, r *http.Request, userID int64) {
+	// Start a new token family for this login.
+	family, err := data.NewTokenFamily()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	// Generate a new pair of authentication and refresh token.
+	authToken, refreshToken, err := app.newAuthenticationTokens(r, userID, family)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Record the login.
+	app.recordAuditEvent(r, audit.Event{ActorID: userID, Action: audit.ActionLoginSuccess, TargetType: audit.TargetUser, TargetID: userID})
+
+	// Send the response to the client.
+	env := envelope{"authentication_token": authToken, "refresh_token": refreshToken}
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// newAuthenticationTokens generates a short-lived authentication token and a long-lived
+// refresh token in the token family for the user, and records the client of request r.
+func (app *application) newAuthenticationTokens(r *http.Request, userID int64, family []byte) (*data.Token, *data.Token, error) {
+	ip, userAgent := realip.FromRequest(r), r.UserAgent()
+
 	// Generate a new authentication token.
-	token, err := app.models.Tokens.New(user.ID, data.TokenExpireTimeAuthentication, data.ScopeAuthentication)
+	var authToken *data.Token
+	var err error
+	if app.signer != nil {
+		authToken, err = app.newSignedAuthenticationTokenForUser(userID, family)
+	} else {
+		authToken, err = app.models.Tokens.NewForFamily(family, userID, app.config.tokens.authenticationTTL, data.ScopeAuthentication, ip, userAgent)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Generate a new refresh token.
+	refreshToken, err := app.models.Tokens.NewForFamily(family, userID, app.config.tokens.refreshTTL, data.ScopeRefresh, ip, userAgent)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return authToken, refreshToken, nil
+}
+
+// newSignedAuthenticationTokenForUser loads the user and the permissions of the user
+// from DB, and returns a signed authentication token carrying them.
+func (app *application) newSignedAuthenticationTokenForUser(userID int64, family []byte) (*data.Token, error) {
+	user, err := app.models.Users.Get(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return app.newSignedAuthenticationToken(user, permissions, family)
+}
+
+// refreshAuthenticationTokenHandler exchanges the one-time-use refresh token in the request
+// for a new pair of authentication and refresh token.
+// If a refresh token is used twice, the whole token family is revoked since the
+// refresh token is likely to be stolen.
+func (app *application) refreshAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse the input.
+	var input struct {
+		TokenPlaintext string `json:"refresh_token"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Validate the refresh token.
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Get the refresh token from DB.
+	token, err := app.models.Tokens.GetForPlaintext(data.ScopeRefresh, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Mark the refresh token as used. Revoke the whole token family if it was used before.
+	if token.UsedAt == nil {
+		err = app.models.Tokens.MarkUsed(token)
+	} else {
+		err = data.ErrTokenReused
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTokenReused):
+			app.revokeTokenFamily(w, r, token)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Generate a new pair of authentication and refresh token in the same family.
+	authToken, refreshToken, err := app.newAuthenticationTokens(r, token.UserID, token.Family)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
 	// Send the response to the client.
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	env := envelope{"authentication_token": authToken, "refresh_token": refreshToken}
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revokeTokenFamily revokes all tokens in the family of the reused refresh token,
+// and sends the Status Unauthorized Error response to the client.
+func (app *application) revokeTokenFamily(w http.ResponseWriter, r *http.Request, token *data.Token) {
+	app.logger.PrintInfo("refresh token reuse detected, revoking token family", map[string]string{
+		"user_id": strconv.FormatInt(token.UserID, 10),
+		"ip":      realip.FromRequest(r),
+	})
+
+	err := app.models.Tokens.DeleteFamily(token.Family)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Record the revocation. The actor is unknown since the token is likely to be stolen.
+	app.recordAuditEvent(r, audit.Event{Action: audit.ActionTokenReuse, TargetType: audit.TargetUser, TargetID: token.UserID})
+
+	app.invalidAuthenticationTokenResponse(w, r)
+}
+
+// createMagicLinkTokenHandler generates a one-time magic-link token for the activated user
+// with the email provided in the request, and sends the token to that email.
+// It always responds in the same way to avoid revealing whether the user exists.
+func (app *application) createMagicLinkTokenHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse the input.
+	var input struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Validate the email.
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Get the user by email.
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Only activated users are allowed to log in with a magic link.
+	if user != nil && user.Activated {
+		// Delete the old magic-link tokens of this user, so that only the latest link works.
+		err = app.models.Tokens.DeleteAllForUser(data.ScopeMagicLink, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// Generate a new magic-link token.
+		token, err := app.models.Tokens.NewForClient(user.ID, app.config.tokens.magicLinkTTL, data.ScopeMagicLink, realip.FromRequest(r), r.UserAgent())
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// Send the magic-link token to the user in the background.
+		app.background(func() {
+			data := map[string]interface{}{
+				"magicLinkToken":       token.Plaintext,
+				"magicLinkTokenExpiry": token.Expiry.Format(time.RFC1123),
+			}
+
+			err := app.mailer.Send(user.Email, "token_magic_link.tmpl", data)
+			if err != nil {
+				app.logger.PrintError(err.Error(), nil)
+			}
+		})
+	}
+
+	// Inform the client that the email will be sent.
+	env := envelope{"message": "if the email address belongs to an activated account, an email will be sent to you containing a login link"}
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createMagicLinkAuthenticationTokenHandler consumes the magic-link token in the request
+// and logs the user in as createAuthenticationTokenHandler does after checking the password.
+func (app *application) createMagicLinkAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse the input.
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Validate the token.
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Consume the token, so that it cannot be replayed.
+	userID, err := app.models.Tokens.Consume(data.ScopeMagicLink, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Get the user of the token.
+	user, err := app.models.Users.Get(userID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Issue the tokens, or ask for the second factor if the user has enabled it.
+	app.completeLogin(w, r, user)
+}
+
+// createPasswordResetTokenHandler generates a password reset token for the user
+// with the email provided in the request, and sends the token to that email.
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse the input.
+	var input struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Validate the email.
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Get the user by email.
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Only activated users are allowed to reset their password. Other emails get the same
+	// response without an email, so that the response does not reveal the registered emails.
+	if err == nil && user.Activated {
+		// Generate a new password reset token.
+		token, err := app.models.Tokens.New(user.ID, app.config.tokens.passwordResetTTL, data.ScopePasswordReset)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// Send the password reset token to the user in the background.
+		app.background(func() {
+			data := map[string]interface{}{
+				"passwordResetToken":       token.Plaintext,
+				"passwordResetTokenExpiry": token.Expiry.Format(time.RFC1123),
+			}
+
+			err := app.mailer.Send(user.Email, "token_password_reset.tmpl", data)
+			if err != nil {
+				app.logger.PrintError(err.Error(), nil)
+			}
+		})
+	}
+
+	// Inform the client that the email will be sent.
+	env := envelope{"message": "an email will be sent to you containing password reset instructions"}
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createActivationTokenHandler generates a new activation token for the user
+// with the email provided in the request, and sends the token to that email.
+func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse the input.
+	var input struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Validate the email.
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Get the user by email.
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("email", "no matching email address found")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Refuse the request if the user has already been activated.
+	if user.Activated {
+		v.AddError("email", "user has already been activated")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Delete the old activation tokens of this user.
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
 	}
 
+	// Generate a new activation token.
+	token, err := app.models.Tokens.New(user.ID, app.config.tokens.activationTTL, data.ScopeActivation)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Send the activation token to the user in the background.
+	app.background(func() {
+		data := map[string]interface{}{
+			"activationToken":       token.Plaintext,
+			"activationTokenExpiry": token.Expiry.Format(time.RFC1123),
+		}
+
+		err = app.mailer.Send(user.Email, "token_activation.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err.Error(), nil)
+		}
+	})
+
+	// Inform the client that the email will be sent.
+	env := envelope{"message": "an email will be sent to you containing activation instructions"}
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listAuthenticationTokensHandler lists the active sessions, i.e. the unexpired
+// authentication tokens, of the current user.
+func (app *application) listAuthenticationTokensHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the user.
+	user := app.contextGetUser(r)
+
+	// Get all authentication tokens of this user.
+	tokens, err := app.models.Tokens.GetAllForUser(data.ScopeAuthentication, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Send the sessions to the client.
+	err = app.writeJSON(w, http.StatusOK, envelope{"authentication_tokens": tokens}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteAuthenticationTokenHandler revokes the authentication token presented
+// in the Authorization header of the request, together with the refresh tokens
+// issued from the same login.
+func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	// Revoke the signed token by the deny-list.
+	if plaintext := app.contextGetToken(r); isSignedToken(plaintext) {
+		app.deleteSignedAuthenticationToken(w, r, plaintext)
+		return
+	}
+
+	// Get the token of this request.
+	token, err := app.models.Tokens.GetForPlaintext(data.ScopeAuthentication, app.contextGetToken(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Delete the token, or its whole family if it has one, from DB.
+	if token.Family != nil {
+		err = app.models.Tokens.DeleteFamily(token.Family)
+	} else {
+		err = app.models.Tokens.DeleteForPlaintext(data.ScopeAuthentication, token.Plaintext)
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Record the revocation.
+	app.recordAuditEvent(r, audit.Event{Action: audit.ActionTokenRevoke, TargetType: audit.TargetUser, TargetID: token.UserID})
+
+	// Inform the client that the token is revoked.
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "authentication token successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteSignedAuthenticationToken adds the signed token to the deny-list, and revokes
+// the refresh tokens issued from the same login.
+func (app *application) deleteSignedAuthenticationToken(w http.ResponseWriter, r *http.Request, plaintext string) {
+	// Get the claims of the token.
+	claims, err := app.verifySignedToken(plaintext)
+	if err != nil {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	// Revoke the token.
+	err = app.revokeSignedToken(claims)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Delete the refresh tokens in the family of this token.
+	if claims.Family != "" {
+		family, err := base64.RawURLEncoding.DecodeString(claims.Family)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		err = app.models.Tokens.DeleteFamily(family)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// Record the revocation.
+	app.recordAuditEvent(r, audit.Event{Action: audit.ActionTokenRevoke, TargetType: audit.TargetUser, TargetID: app.contextGetUser(r).ID})
+
+	// Inform the client that the token is revoked.
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "authentication token successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteAllAuthenticationTokensHandler revokes all authentication and refresh tokens and API keys of the current user.
+func (app *application) deleteAllAuthenticationTokensHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the user.
+	user := app.contextGetUser(r)
+
+	// Revoke all authentication and refresh tokens and API keys of this user.
+	err := app.revokeAllSessions(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Record the revocation.
+	app.recordAuditEvent(r, audit.Event{Action: audit.ActionTokenRevokeAll, TargetType: audit.TargetUser, TargetID: user.ID})
+
+	// Inform the client that the tokens are revoked.
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "all authentication tokens and API keys successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"greenlight.kerseeehuang.com/internal/audit"
+	"greenlight.kerseeehuang.com/internal/data"
+	"greenlight.kerseeehuang.com/internal/validator"
+)
+
+// listTrashedMoviesHandler lists the movies in trash with given query in r.URL.Values.
+func (app *application) listTrashedMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+
+	// Prepare a validator.
+	v := validator.New()
+
+	// Get the query.
+	qs := r.URL.Query()
+
+	// Populate the input struct.
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-deleted_at")
+	input.Filters.SortSafelist = []string{"id", "title", "deleted_at", "-id", "-title", "-deleted_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Get the trashed movies from DB.
+	movies, metadata, err := app.models.Movies.GetAllDeleted(input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Write the movies to response.
+	err = app.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "movies": movies}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// restoreMovieHandler moves the movie with given id in the request out of trash.
+func (app *application) restoreMovieHandler(w http.ResponseWriter, r *http.Request) {
+	// Read the movie id in the request.
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// Restore the movie.
+	movie, err := app.models.Movies.Restore(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Record the restoration.
+	app.recordAuditEvent(r, audit.Event{Action: audit.ActionMovieRestore, TargetType: audit.TargetMovie, TargetID: movie.ID, After: movie})
+
+	// Write the restored movie to the response.
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// purgeTrashedMovies permanently deletes the movies whose retention period in trash has passed.
+func (app *application) purgeTrashedMovies() error {
+	deleted, err := app.models.Movies.Purge(time.Now().Add(-app.config.movies.trashRetention))
+	if err != nil {
+		return err
+	}
+
+	if deleted > 0 {
+		app.logger.PrintInfo("purged trashed movies", map[string]string{
+			"count": strconv.FormatInt(deleted, 10),
+		})
+	}
+
+	return nil
+}
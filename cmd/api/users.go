@@ -2,8 +2,13 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"greenlight.kerseeehuang.com/internal/audit"
 	"greenlight.kerseeehuang.com/internal/data"
 	"greenlight.kerseeehuang.com/internal/validator"
 )
@@ -38,9 +43,10 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// Validate this user.
+	// Validate this user and check the password against the password policy.
 	v := validator.New()
 	data.ValidateUser(v, user)
+	app.passwordPolicy.Validate(v, input.Password, user)
 	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
@@ -59,15 +65,15 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// Generate permission of reading movies for this user.
-	err = app.models.Permissions.AddForUser(user.ID, data.PermissionReadMovies)
+	// Assign the viewer role, which permits reading movies, to this user.
+	err = app.models.Roles.AddForUser(user.ID, data.RoleViewer)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
 	// Generate an activating token.
-	token, err := app.models.Tokens.New(user.ID, data.TokenExpireTimeActivation, data.ScopeActivation)
+	token, err := app.models.Tokens.New(user.ID, app.config.tokens.activationTTL, data.ScopeActivation)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -77,8 +83,9 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	app.background(func() {
 
 		data := map[string]interface{}{
-			"activationToken": token.Plaintext,
-			"Email":           user.Email,
+			"activationToken":       token.Plaintext,
+			"activationTokenExpiry": token.Expiry.Format(time.RFC1123),
+			"Email":                 user.Email,
 		}
 
 		err = app.mailer.Send(user.Email, "user_welcome.tmpl", data)
@@ -131,8 +138,6 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 	err = app.models.Users.Update(user)
 	if err != nil {
 		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			app.notFoundResponse(w, r)
 		case errors.Is(err, data.ErrDuplicateEmail):
 			v.AddError("email", "this email address is already in used")
 			app.failedValidationResponse(w, r, v.Errors)
@@ -157,3 +162,638 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// updateUserPasswordHandler resets the password of the user with
+// the password reset token given in the request.
+func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse the new password and the token from the request.
+	var input struct {
+		Password       string `json:"password"`
+		TokenPlaintext string `json:"token"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Validate the password and the token.
+	v := validator.New()
+	data.ValidatePlainPassword(v, input.Password)
+	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Get the user with the token.
+	user, err := app.models.Users.GetForToken(data.ScopePasswordReset, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired password reset token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Check the new password against the password policy.
+	if app.passwordPolicy.Validate(v, input.Password, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Hash the new password of this user.
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Update the user. The version of the user is bumped by UserModel.Update.
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Delete all password reset tokens of this user.
+	err = app.models.Tokens.DeleteAllForUser(data.ScopePasswordReset, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Revoke all authentication and refresh tokens and API keys of this user.
+	err = app.revokeAllSessions(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Record the revocation. The user has proven the ownership with the reset token.
+	app.recordAuditEvent(r, audit.Event{ActorID: user.ID, Action: audit.ActionTokenRevokeAll, TargetType: audit.TargetUser, TargetID: user.ID})
+
+	// Inform the user that the password is reset.
+	env := envelope{"message": "your password was successfully reset"}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// currentUser returns the authenticated user of request r with all information of the user.
+// The user carried by a signed token only holds the ID and activation state, hence
+// the user is loaded from DB in that case.
+func (app *application) currentUser(r *http.Request) (*data.User, error) {
+	user := app.contextGetUser(r)
+	if !isSignedToken(app.contextGetToken(r)) {
+		return user, nil
+	}
+	return app.models.Users.Get(user.ID)
+}
+
+// showCurrentUserHandler shows the authenticated user with the effective permissions of the user.
+func (app *application) showCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the user.
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Get the permissions of this user.
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Write the user to response.
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user, "permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateCurrentUserHandler updates the name of the authenticated user.
+func (app *application) updateCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the user.
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Check the expected version first if it is provided.
+	if expVer := r.Header.Get("X-Expected-Version"); expVer != "" && strconv.Itoa(user.Version) != expVer {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	// Parse the input.
+	// Use pointer to detect whether keys in input are given or not.
+	var input struct {
+		Name *string `json:"name"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Copy the values from input into user.
+	if input.Name != nil {
+		user.Name = *input.Name
+	}
+
+	// Validate the user.
+	v := validator.New()
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Update the user. The version of the user guards against concurrent updates.
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Send updated details to the user.
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateCurrentUserPasswordHandler changes the password of the authenticated user after
+// confirming the current password, and revokes the other sessions of the user.
+func (app *application) updateCurrentUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse the input.
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		Password        string `json:"password"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Validate the input.
+	v := validator.New()
+	v.Check(input.CurrentPassword != "", "current_password", validator.ErrMsgMustBeProvided)
+	data.ValidatePlainPassword(v, input.Password)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Get the user.
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Check if the current password is correct.
+	match, err := user.Password.Matches(input.CurrentPassword)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !match {
+		v.AddError("current_password", "incorrect password")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Check the new password against the password policy.
+	if app.passwordPolicy.Validate(v, input.Password, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Hash the new password of this user.
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Update the user.
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Revoke all other sessions of this user.
+	err = app.revokeOtherSessions(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Inform the user that the password is changed.
+	env := envelope{"message": "your password was successfully changed"}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateCurrentUserEmailHandler stores the new email address in the request as the pending
+// email of the authenticated user, and sends a confirmation token to the new address.
+// The email address of the user is only changed after the token is confirmed.
+func (app *application) updateCurrentUserEmailHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse the input.
+	var input struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Get the user.
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Validate the email.
+	v := validator.New()
+	data.ValidateEmail(v, input.Email)
+	v.Check(!strings.EqualFold(input.Email, user.Email), "email", "must be different from the current email address")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Check if the email address has been used by other users. The response does not
+	// tell whether it is, so that users cannot find out which addresses are registered.
+	taken := true
+	_, err = app.models.Users.GetByEmail(input.Email)
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		taken = false
+	case err != nil:
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Store the pending email of this user.
+	user.PendingEmail = &input.Email
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Delete the old email change tokens of this user, which are for previous pending emails.
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeEmailChange, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Generate an email change token, unless the new address is taken and cannot be confirmed.
+	var token *data.Token
+	if !taken {
+		token, err = app.models.Tokens.New(user.ID, app.config.tokens.emailChangeTTL, data.ScopeEmailChange)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// Send the token to the new address, or a notification to its owner if it is taken,
+	// and a notification to the current address.
+	app.background(func() {
+		data := map[string]interface{}{
+			"newEmail": input.Email,
+		}
+
+		if token != nil {
+			data["emailChangeToken"] = token.Plaintext
+			data["emailChangeTokenExpiry"] = token.Expiry.Format(time.RFC1123)
+			err := app.mailer.Send(input.Email, "email_change_confirm.tmpl", data)
+			if err != nil {
+				app.logger.PrintError(err.Error(), nil)
+			}
+		} else {
+			err := app.mailer.Send(input.Email, "email_change_taken.tmpl", data)
+			if err != nil {
+				app.logger.PrintError(err.Error(), nil)
+			}
+		}
+
+		err := app.mailer.Send(user.Email, "email_change_notice.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err.Error(), nil)
+		}
+	})
+
+	// Inform the user that the confirmation email will be sent.
+	env := envelope{"message": "an email will be sent to the new address containing confirmation instructions", "user": user}
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// confirmUserEmailHandler changes the email address of the user to the pending email
+// with the email change token given in the request.
+func (app *application) confirmUserEmailHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the token from the request.
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Validate the token.
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Get the user with the token.
+	user, err := app.models.Users.GetForToken(data.ScopeEmailChange, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if user.PendingEmail == nil {
+		v.AddError("token", "invalid or expired")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Swap the email address of this user.
+	user.Email = *user.PendingEmail
+	user.PendingEmail = nil
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "this email address is already in used")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Delete all email change tokens of this user in DB.
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeEmailChange, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Send updated details to the user.
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// exportCurrentUserHandler sends an archive of the personal data of the authenticated user,
+// including the user, the roles, the permissions, the active sessions, the API keys, the
+// movie revisions written by the user and the audit events of the actions of the user.
+func (app *application) exportCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the user.
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Get the roles and permissions of this user.
+	roles, err := app.models.Roles.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Get the active sessions of this user.
+	sessions := make(map[string][]*data.Token)
+	for _, scope := range []string{data.ScopeAuthentication, data.ScopeRefresh} {
+		tokens, err := app.models.Tokens.GetAllForUser(scope, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		sessions[scope] = tokens
+	}
+
+	// Get the API keys of this user.
+	keys, err := app.models.APIKeys.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Get the movie revisions written by this user.
+	revisions, err := app.models.MovieRevisions.GetAllForAuthor(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Get the audit events of the actions of this user.
+	events, err := app.models.AuditEvents.GetAllForActor(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Send the archive as a downloadable JSON file.
+	headers := make(http.Header)
+	headers.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="greenlight-user-%d.json"`, user.ID))
+
+	env := envelope{
+		"exported_at":  time.Now(),
+		"user":         user,
+		"roles":        roles,
+		"permissions":  permissions,
+		"sessions":     sessions,
+		"api_keys":     keys,
+		"revisions":    revisions,
+		"audit_events": events,
+	}
+	err = app.writeJSON(w, http.StatusOK, env, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteCurrentUserHandler schedules the deletion of the authenticated user after confirming
+// the password, and revokes all sessions of the user. The user is deleted after the grace
+// period, during which the deletion can be cancelled by logging in again.
+func (app *application) deleteCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse the input.
+	var input struct {
+		Password string `json:"password"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Validate the input.
+	v := validator.New()
+	if v.Check(input.Password != "", "password", validator.ErrMsgMustBeProvided); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Get the user.
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Check if the password is correct.
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !match {
+		v.AddError("password", "incorrect password")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Schedule the deletion of this user, unless this user is the last admin.
+	deletionScheduledAt := time.Now().Add(app.config.users.deletionGracePeriod)
+	user.DeletionScheduledAt = &deletionScheduledAt
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrLastAdmin):
+			app.lastAdminResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Revoke all sessions of this user.
+	err = app.revokeAllSessions(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Inform the user about the scheduled deletion.
+	env := envelope{
+		"message":               "your account is scheduled for deletion, log in and cancel the deletion before it is executed to keep your account",
+		"deletion_scheduled_at": deletionScheduledAt,
+	}
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// cancelCurrentUserDeletionHandler cancels the scheduled deletion of the authenticated user.
+func (app *application) cancelCurrentUserDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the user.
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Check if there is a scheduled deletion.
+	if user.DeletionScheduledAt == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// Cancel the deletion.
+	user.DeletionScheduledAt = nil
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Send updated details to the user.
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteScheduledUsers deletes the users whose grace period of deletion has passed.
+func (app *application) deleteScheduledUsers() error {
+	deleted, err := app.models.Users.DeleteScheduled(time.Now())
+	if err != nil {
+		return err
+	}
+
+	if deleted > 0 {
+		app.logger.PrintInfo("deleted users scheduled for deletion", map[string]string{
+			"count": strconv.FormatInt(deleted, 10),
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,236 @@
+// Command oidc is a stub OpenID Connect provider for testing the OpenID Connect login
+// of the API locally. It logs every user in without asking for credentials.
+//
+// Start the stub and the API with:
+//
+//	go run ./cmd/examples/oidc
+//	go run ./cmd/api -oidc-issuer=http://localhost:9096 -oidc-client-id=greenlight
+//
+// and log in by following the redirects with the cookies enabled:
+//
+//	curl -L -b '' 'http://localhost:8080/v1/oidc/login'
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"greenlight.kerseeehuang.com/internal/jwt"
+)
+
+// authorization holds the request of an issued authorization code.
+type authorization struct {
+	clientID    string
+	redirectURI string
+	challenge   string
+	nonce       string
+	email       string
+	expiry      time.Time
+}
+
+// provider is the stub provider.
+type provider struct {
+	issuer   string
+	clientID string
+	email    string
+	name     string
+	key      *rsa.PrivateKey
+	signer   *jwt.KeySet
+
+	mu    sync.Mutex
+	codes map[string]authorization
+}
+
+func main() {
+	// Parse the flags.
+	addr := flag.String("addr", ":9096", "Server address")
+	issuer := flag.String("issuer", "http://localhost:9096", "Issuer URL")
+	clientID := flag.String("client-id", "greenlight", "Client ID accepted by the provider")
+	email := flag.String("email", "sso.user@example.com", "Email of the logged in user, overridden by the login_hint parameter")
+	name := flag.String("name", "SSO User", "Name of the logged in user")
+	flag.Parse()
+
+	// Generate the signing key.
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal(err)
+	}
+	signingKey, err := jwt.NewRSAKey("stub", key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	signer, err := jwt.NewKeySet("stub", signingKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	p := &provider{
+		issuer:   *issuer,
+		clientID: *clientID,
+		email:    *email,
+		name:     *name,
+		key:      key,
+		signer:   signer,
+		codes:    make(map[string]authorization),
+	}
+
+	// Register the endpoints.
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discoveryHandler)
+	mux.HandleFunc("/jwks", p.jwksHandler)
+	mux.HandleFunc("/authorize", p.authorizeHandler)
+	mux.HandleFunc("/token", p.tokenHandler)
+
+	// Log the starting server message.
+	log.Printf("starting stub OpenID Connect provider %s on %s", *issuer, *addr)
+	log.Fatal(http.ListenAndServe(*addr, mux))
+}
+
+// discoveryHandler serves the provider metadata.
+func (p *provider) discoveryHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.issuer,
+		"authorization_endpoint":                p.issuer + "/authorize",
+		"token_endpoint":                        p.issuer + "/token",
+		"jwks_uri":                              p.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{jwt.AlgorithmRS256},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+// jwksHandler serves the public signing key.
+func (p *provider) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	enc := base64.RawURLEncoding
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "stub",
+			"use": "sig",
+			"alg": jwt.AlgorithmRS256,
+			"n":   enc.EncodeToString(p.key.N.Bytes()),
+			"e":   enc.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}},
+	})
+}
+
+// authorizeHandler logs the user in without asking for credentials, and redirects
+// the user back to the client with an authorization code.
+func (p *provider) authorizeHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	// Check the authorization request.
+	if qs.Get("client_id") != p.clientID || qs.Get("response_type") != "code" || qs.Get("redirect_uri") == "" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+	if qs.Get("code_challenge") == "" || qs.Get("code_challenge_method") != "S256" {
+		http.Error(w, "PKCE with S256 is required", http.StatusBadRequest)
+		return
+	}
+
+	email := p.email
+	if hint := qs.Get("login_hint"); hint != "" {
+		email = hint
+	}
+
+	// Issue an authorization code.
+	code := randomString()
+	p.mu.Lock()
+	p.codes[code] = authorization{
+		clientID:    qs.Get("client_id"),
+		redirectURI: qs.Get("redirect_uri"),
+		challenge:   qs.Get("code_challenge"),
+		nonce:       qs.Get("nonce"),
+		email:       email,
+		expiry:      time.Now().Add(time.Minute),
+	}
+	p.mu.Unlock()
+
+	// Redirect the user back to the client.
+	redirect, err := url.Parse(qs.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	params := redirect.Query()
+	params.Set("code", code)
+	params.Set("state", qs.Get("state"))
+	redirect.RawQuery = params.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// tokenHandler exchanges an authorization code and its PKCE code verifier for an ID token.
+func (p *provider) tokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Consume the authorization code.
+	p.mu.Lock()
+	auth, ok := p.codes[r.PostFormValue("code")]
+	delete(p.codes, r.PostFormValue("code"))
+	p.mu.Unlock()
+
+	// Check the token request.
+	challenge := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || time.Now().After(auth.expiry) ||
+		r.PostFormValue("grant_type") != "authorization_code" ||
+		r.PostFormValue("client_id") != auth.clientID ||
+		r.PostFormValue("redirect_uri") != auth.redirectURI ||
+		base64.RawURLEncoding.EncodeToString(challenge[:]) != auth.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	// Sign the ID token.
+	now := time.Now()
+	idToken, err := p.signer.Sign(map[string]interface{}{
+		"iss":            p.issuer,
+		"sub":            "stub|" + auth.email,
+		"aud":            auth.clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          auth.nonce,
+		"email":          auth.email,
+		"email_verified": true,
+		"name":           p.name,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+// writeJSON writes data as JSON with the status code.
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+// randomString returns a random base64url-encoded string.
+func randomString() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
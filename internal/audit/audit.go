@@ -0,0 +1,149 @@
+// Package audit records security-relevant and data-changing actions as audit events,
+// with the before/after values of the fields changed by each action.
+package audit
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+
+	"github.com/tomasen/realip"
+	"greenlight.kerseeehuang.com/internal/data"
+)
+
+// Actions of audit events.
+const (
+	ActionMovieCreate      = "movie.create"
+	ActionMovieUpdate      = "movie.update"
+	ActionMovieDelete      = "movie.delete"
+	ActionMovieRevert      = "movie.revert"  // restoration of an older version of a movie
+	ActionMovieRestore     = "movie.restore" // restoration of a movie from trash
+	ActionLoginSuccess     = "login.success"
+	ActionLoginFailure     = "login.failure"
+	ActionTokenRevoke      = "token.revoke"      // revocation of the tokens of a login
+	ActionTokenRevokeAll   = "token.revoke_all"  // revocation of all tokens of a user
+	ActionTokenReuse       = "token.reuse"       // revocation of a token family on refresh token reuse
+	ActionAPIKeyDelete     = "api_key.delete"    // revocation of an API key
+	ActionPermissionGrant  = "permission.grant"  // grant of a permission to a user
+	ActionPermissionRevoke = "permission.revoke" // revocation of a permission from a user
+	ActionRoleAssign       = "role.assign"       // assignment of a role to a user
+	ActionRoleUnassign     = "role.unassign"     // unassignment of a role from a user
+	ActionUserActivation   = "user.activation"   // activation or deactivation of a user by an admin
+	ActionUserUnlock       = "user.unlock"       // removal of the lockout of a user by an admin
+)
+
+// Types of the targets of audit events.
+const (
+	TargetMovie  = "movie"
+	TargetUser   = "user"
+	TargetAPIKey = "api_key"
+)
+
+// Event describes an action to be recorded.
+type Event struct {
+	ActorID    int64       // ID of the user doing the action, 0 if unknown
+	Action     string      // one of the Action constants
+	TargetType string      // one of the Target constants
+	TargetID   int64       // ID of the target, 0 if unknown
+	Before     interface{} // target before the action, nil if created
+	After      interface{} // target after the action, nil if deleted
+}
+
+// Change holds the values of a field before and after an action.
+type Change struct {
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// Recorder stores audit events into DB.
+type Recorder struct {
+	events data.AuditEventModel
+}
+
+// New returns a Recorder storing audit events with model events.
+func New(events data.AuditEventModel) *Recorder {
+	return &Recorder{events: events}
+}
+
+// Record stores event done by the client of request r, whose IP address is taken from
+// the proxy headers of r if there are any.
+func (rec *Recorder) Record(r *http.Request, event Event) error {
+	// Compute the changed fields of the target.
+	changes, err := Diff(event.Before, event.After)
+	if err != nil {
+		return err
+	}
+
+	// Build the audit event.
+	auditEvent := &data.AuditEvent{
+		IP:         realip.FromRequest(r),
+		Action:     event.Action,
+		TargetType: event.TargetType,
+	}
+	if event.ActorID != 0 {
+		auditEvent.ActorID = &event.ActorID
+	}
+	if event.TargetID != 0 {
+		auditEvent.TargetID = strconv.FormatInt(event.TargetID, 10)
+	}
+	if len(changes) > 0 {
+		auditEvent.Changes, err = json.Marshal(changes)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rec.events.Insert(auditEvent)
+}
+
+// Diff returns the fields whose JSON values differ between before and after, which are
+// values encoded as JSON objects. A nil before or after is treated as an empty object,
+// so that all fields are reported for created or deleted targets.
+func Diff(before, after interface{}) (map[string]Change, error) {
+	// Decode both values into their JSON fields.
+	beforeFields, err := fields(before)
+	if err != nil {
+		return nil, err
+	}
+	afterFields, err := fields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	// Collect the removed and changed fields.
+	changes := make(map[string]Change)
+	for key, b := range beforeFields {
+		if a, ok := afterFields[key]; !ok || !reflect.DeepEqual(a, b) {
+			changes[key] = Change{Before: b, After: a}
+		}
+	}
+
+	// Collect the added fields.
+	for key, a := range afterFields {
+		if _, ok := beforeFields[key]; !ok {
+			changes[key] = Change{After: a}
+		}
+	}
+
+	return changes, nil
+}
+
+// fields returns the fields of the JSON object encoding v, or nil if v is nil.
+func fields(v interface{}) (map[string]interface{}, error) {
+	if v == nil || (reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil()) {
+		return nil, nil
+	}
+
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[string]interface{}
+	err = json.Unmarshal(js, &m)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
@@ -0,0 +1,269 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.kerseeehuang.com/internal/validator"
+)
+
+const (
+	APIKeyPrefix = "glk_" // prefix making API keys recognisable, e.g. by secret scanners
+
+	apiKeyLength        = len(APIKeyPrefix) + 32 // length of a plaintext API key
+	apiKeyDisplayLength = len(APIKeyPrefix) + 8  // length of the part of a key shown after creation
+)
+
+// APIKey is a long-lived named key of a user for service-to-service access.
+type APIKey struct {
+	ID          int64       `json:"id"`
+	UserID      int64       `json:"-"`
+	Name        string      `json:"name"`
+	Prefix      string      `json:"prefix"`        // first characters of the key to recognise it
+	Plaintext   string      `json:"key,omitempty"` // only available right after creation
+	Hash        []byte      `json:"-"`
+	Permissions Permissions `json:"permissions"` // nil means all permissions of the user
+	CreatedAt   time.Time   `json:"created_at"`
+	LastUsedAt  *time.Time  `json:"last_used_at"`
+	Expiry      *time.Time  `json:"expiry"` // nil means the key never expires
+}
+
+// IsAPIKey reports whether plaintext looks like an API key rather than a token.
+func IsAPIKey(plaintext string) bool {
+	return strings.HasPrefix(plaintext, APIKeyPrefix)
+}
+
+// NewAPIKey returns a new API key of the user with a random plaintext.
+func NewAPIKey(userID int64, name string, permissions Permissions, expiry *time.Time) (*APIKey, error) {
+	// Generate random bytes.
+	randomBytes := make([]byte, 20)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	// Encode the random bytes with the prefix and hash the plaintext.
+	plaintext := APIKeyPrefix + strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes))
+	hash := sha256.Sum256([]byte(plaintext))
+
+	return &APIKey{
+		UserID:      userID,
+		Name:        name,
+		Prefix:      plaintext[:apiKeyDisplayLength],
+		Plaintext:   plaintext,
+		Hash:        hash[:],
+		Permissions: permissions,
+		CreatedAt:   time.Now(),
+		Expiry:      expiry,
+	}, nil
+}
+
+// ValidateAPIKeyPlaintext validates the plaintext of an API key and stores error messages into v.
+func ValidateAPIKeyPlaintext(v *validator.Validator, plaintext string) {
+	v.Check(plaintext != "", "key", validator.ErrMsgMustBeProvided)
+	v.Check(IsAPIKey(plaintext), "key", "must start with "+APIKeyPrefix)
+	v.Check(len(plaintext) == apiKeyLength, "key", "invalid length")
+}
+
+// ValidateAPIKey validates the name, permissions and expiry of key and stores error messages into v.
+func ValidateAPIKey(v *validator.Validator, key *APIKey) {
+	v.Check(key.Name != "", "name", validator.ErrMsgMustBeProvided)
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long")
+	if key.Permissions != nil {
+		v.Check(len(key.Permissions) > 0, "permissions", "must contain at least 1 permission if provided")
+		v.Check(validator.Unique(key.Permissions), "permissions", "must not contain duplicate values")
+	}
+	if key.Expiry != nil {
+		v.Check(key.Expiry.After(time.Now()), "expiry", "must be in the future")
+	}
+}
+
+// APIKeyModel is a wrapper of DB connection pool.
+type APIKeyModel struct {
+	DB *sql.DB
+}
+
+// Insert inserts key into DB, and sets the ID of key.
+func (m APIKeyModel) Insert(key *APIKey) error {
+	// Prepare the query.
+	query := `
+		INSERT INTO api_keys (user_id, name, prefix, hash, permissions, created_at, expiry)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id`
+
+	// The permissions are stored as NULL when all permissions of the user are allowed.
+	var permissions interface{}
+	if key.Permissions != nil {
+		permissions = pq.Array(key.Permissions)
+	}
+	args := []interface{}{key.UserID, key.Name, key.Prefix, key.Hash, permissions, key.CreatedAt, key.Expiry}
+
+	// Prepare the context.
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// Execute the query.
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&key.ID)
+}
+
+// GetForPlaintext returns the unexpired API key with given plaintext.
+// If no matching key is found in DB, return data.ErrRecordNotFound.
+func (m APIKeyModel) GetForPlaintext(plaintext string) (*APIKey, error) {
+	// Prepare the query.
+	query := `
+		SELECT id, user_id, name, prefix, hash, permissions, created_at, last_used_at, expiry
+		FROM api_keys
+		WHERE hash = $1 AND (expiry IS NULL OR expiry > $2)`
+
+	hash := sha256.Sum256([]byte(plaintext))
+
+	// Prepare the context.
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// Execute the query.
+	key, err := scanAPIKey(m.DB.QueryRowContext(ctx, query, hash[:], time.Now()))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+// GetAllForUser returns all API keys of the user including the expired ones, ordered
+// from the newest to the oldest.
+func (m APIKeyModel) GetAllForUser(userID int64) ([]*APIKey, error) {
+	// Prepare the query.
+	query := `
+		SELECT id, user_id, name, prefix, hash, permissions, created_at, last_used_at, expiry
+		FROM api_keys
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC`
+
+	// Prepare the context.
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// Execute the query.
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Copy the keys from result rows.
+	keys := []*APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	// Return scan errors if there is any.
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// UpdateLastUsed records the current time as the last used time of the API key,
+// if it is older than lastUsedResolution.
+func (m APIKeyModel) UpdateLastUsed(id int64) error {
+	query := `
+		UPDATE api_keys
+		SET last_used_at = $1
+		WHERE id = $2 AND (last_used_at IS NULL OR last_used_at < $3)`
+
+	now := time.Now()
+	args := []interface{}{now, id, now.Add(-lastUsedResolution)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Delete deletes the API key with id of the user.
+// If the user has no such key, return data.ErrRecordNotFound.
+func (m APIKeyModel) Delete(id, userID int64) error {
+	// Prepare the query.
+	query := `
+		DELETE FROM api_keys
+		WHERE id = $1 AND user_id = $2`
+
+	// Prepare the context.
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// Execute the query.
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteAllForUser deletes all API keys of the user.
+func (m APIKeyModel) DeleteAllForUser(userID int64) error {
+	// Prepare the query.
+	query := `
+		DELETE FROM api_keys
+		WHERE user_id = $1`
+
+	// Prepare the context.
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// Execute the query.
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
+
+// scanAPIKey scans a row of api_keys selected in the column order of GetForPlaintext.
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (*APIKey, error) {
+	var key APIKey
+	var permissions []string
+	err := row.Scan(
+		&key.ID,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		&key.Hash,
+		pq.Array(&permissions),
+		&key.CreatedAt,
+		&key.LastUsedAt,
+		&key.Expiry,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if permissions != nil {
+		key.Permissions = permissions
+	}
+
+	return &key, nil
+}
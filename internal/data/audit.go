@@ -0,0 +1,174 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// AuditEvent is a record of a security-relevant or data-changing action.
+type AuditEvent struct {
+	ID         int64           `json:"id"`
+	CreatedAt  time.Time       `json:"created_at"`
+	ActorID    *int64          `json:"actor_id"` // nil if the action is not done by an authenticated user
+	IP         string          `json:"ip"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	TargetID   string          `json:"target_id"`
+	Changes    json.RawMessage `json:"changes,omitempty"` // before/after values of the changed fields
+}
+
+// AuditEventFilter holds the conditions of listing audit events. Empty fields match all events.
+type AuditEventFilter struct {
+	ActorID    *int64
+	Action     string
+	TargetType string
+	TargetID   string
+	Since      *time.Time
+	Until      *time.Time
+}
+
+// AuditEventModel is a wrapper of DB connection pool. Audit events are not deleted
+// with their actors or targets, so that the history is kept after a user is deleted.
+// The actor and the IP address of the events of a deleted actor are cleared instead.
+type AuditEventModel struct {
+	DB *sql.DB
+}
+
+// Insert inserts a new audit event into DB, and sets the ID and the creation time of event.
+func (m AuditEventModel) Insert(event *AuditEvent) error {
+	// Define the query of inserting an audit event.
+	query := `
+		INSERT INTO audit_events (actor_id, ip, action, target_type, target_id, changes)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at`
+
+	// Store the changes as NULL if nothing is changed.
+	var changes interface{}
+	if len(event.Changes) > 0 {
+		changes = string(event.Changes)
+	}
+	args := []interface{}{event.ActorID, event.IP, event.Action, event.TargetType, event.TargetID, changes}
+
+	// Create a context with 3-second timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// Execute the query.
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&event.ID, &event.CreatedAt)
+}
+
+// GetAll returns the audit events matching filter, and the pagination metadata.
+func (m AuditEventModel) GetAll(filter AuditEventFilter, filters Filters) ([]*AuditEvent, Metadata, error) {
+	// Define the query of getting results.
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, actor_id, ip, action, target_type, target_id, changes
+		FROM audit_events
+		WHERE (actor_id = $1 OR $1 IS NULL)
+		AND (action = $2 OR $2 = '')
+		AND (target_type = $3 OR $3 = '')
+		AND (target_id = $4 OR $4 = '')
+		AND (created_at >= $5 OR $5 IS NULL)
+		AND (created_at < $6 OR $6 IS NULL)
+		ORDER BY %s
+		LIMIT $7 OFFSET $8`, filters.orderBy("id"))
+
+	// Create a context with 3-second timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// Execute the query.
+	args := []interface{}{filter.ActorID, filter.Action, filter.TargetType, filter.TargetID, filter.Since, filter.Until, filters.limit(), filters.offset()}
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	// Read the rows and store information into events.
+	var totalRecords int
+	events := []*AuditEvent{}
+	for rows.Next() {
+		var event AuditEvent
+		var changes []byte
+		err := rows.Scan(
+			&totalRecords,
+			&event.ID,
+			&event.CreatedAt,
+			&event.ActorID,
+			&event.IP,
+			&event.Action,
+			&event.TargetType,
+			&event.TargetID,
+			&changes,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		event.Changes = changes
+		events = append(events, &event)
+	}
+
+	// Check if any error happens during row scan.
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	// Calculate the metadata based on totalRecords.
+	metadata := calculateMatadata(totalRecords, filters.Page, filters.PageSize)
+
+	return events, metadata, nil
+}
+
+// GetAllForActor returns all audit events of the actions done by the user with actorID,
+// ordered from the oldest to the newest.
+func (m AuditEventModel) GetAllForActor(actorID int64) ([]*AuditEvent, error) {
+	// Define the query of getting results.
+	query := `
+		SELECT id, created_at, actor_id, ip, action, target_type, target_id, changes
+		FROM audit_events
+		WHERE actor_id = $1
+		ORDER BY created_at, id`
+
+	// Create a context with 3-second timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// Execute the query.
+	rows, err := m.DB.QueryContext(ctx, query, actorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Read the rows and store information into events.
+	events := []*AuditEvent{}
+	for rows.Next() {
+		var event AuditEvent
+		var changes []byte
+		err := rows.Scan(
+			&event.ID,
+			&event.CreatedAt,
+			&event.ActorID,
+			&event.IP,
+			&event.Action,
+			&event.TargetType,
+			&event.TargetID,
+			&changes,
+		)
+		if err != nil {
+			return nil, err
+		}
+		event.Changes = changes
+		events = append(events, &event)
+	}
+
+	// Check if any error happens during row scan.
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
@@ -0,0 +1,117 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"greenlight.kerseeehuang.com/internal/validator"
+)
+
+// Facets of movie listings.
+const (
+	FacetGenres        = "genres"         // number of movies of each genre
+	FacetYear          = "year"           // number of movies of each year
+	FacetDecade        = "decade"         // number of movies of each decade, such as "1990"
+	FacetRuntimeBucket = "runtime_bucket" // number of movies of each runtime range, such as "90-119"
+)
+
+// MovieFacets lists the facets which can be counted for movie listings.
+var MovieFacets = []string{FacetGenres, FacetYear, FacetDecade, FacetRuntimeBucket}
+
+// runtimeBucketSize is the width in minutes of the runtime ranges of FacetRuntimeBucket.
+const runtimeBucketSize = 30
+
+// movieFacetQueries holds the SQL queries counting the values of each facet in matching_movies,
+// with the position of each value in the facet. Genres are ordered by their counts,
+// and the other values are ordered by themselves.
+var movieFacetQueries = map[string]string{
+	FacetGenres: `
+		SELECT 'genres', genre, count(*), -count(*)
+		FROM matching_movies, unnest(genres) AS genre
+		GROUP BY genre`,
+	FacetYear: `
+		SELECT 'year', year::text, count(*), year
+		FROM matching_movies
+		GROUP BY year`,
+	FacetDecade: `
+		SELECT 'decade', (year / 10 * 10)::text, count(*), year / 10 * 10
+		FROM matching_movies
+		GROUP BY year / 10 * 10`,
+	FacetRuntimeBucket: fmt.Sprintf(`
+		SELECT 'runtime_bucket', (runtime / %[1]d * %[1]d)::text || '-' || (runtime / %[1]d * %[1]d + %[1]d - 1)::text, count(*), runtime / %[1]d * %[1]d
+		FROM matching_movies
+		GROUP BY runtime / %[1]d * %[1]d`, runtimeBucketSize),
+}
+
+// FacetCount stores the number of movies with a value of a facet.
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// ValidateFacets checks the facets requested for movie listings.
+func ValidateFacets(v *validator.Validator, facets []string) {
+	for _, facet := range facets {
+		v.Check(validator.In(facet, MovieFacets...), "facets", "invalid facet value")
+	}
+	v.Check(validator.Unique(facets), "facets", "values in facets must be unique")
+}
+
+// Facets returns the counts of the values of given facets in the movies matching filter,
+// which are the movies listed by GetAll with the same filter regardless of the page.
+func (m MovieModel) Facets(filter MovieFilter, facets []string) (map[string][]FacetCount, error) {
+	// Count the requested facets only.
+	counts := make(map[string][]FacetCount, len(facets))
+	queries := make([]string, len(facets))
+	for i, facet := range facets {
+		counts[facet] = []FacetCount{}
+		queries[i] = movieFacetQueries[facet]
+	}
+	if len(facets) == 0 {
+		return counts, nil
+	}
+
+	// Define the query of counting the facets of the matching movies. Only the columns
+	// of the facets are selected, so that the ranks are not computed.
+	filterQuery, args := filter.query()
+	query := fmt.Sprintf(`
+		WITH matching_movies AS (
+			SELECT genres, year, runtime
+			FROM (%s
+			) AS movies
+		)
+		SELECT facet, value, count
+		FROM (%s
+		) AS facets (facet, value, count, position)
+		ORDER BY facet, position, value`, filterQuery, strings.Join(queries, "\n\t\tUNION ALL"))
+
+	// Create a context with 3-second timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// Execute the query.
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Read the rows and store the counts into their facets.
+	for rows.Next() {
+		var facet string
+		var count FacetCount
+		err := rows.Scan(&facet, &count.Value, &count.Count)
+		if err != nil {
+			return nil, err
+		}
+		counts[facet] = append(counts[facet], count)
+	}
+
+	// Check if any error happens during row scan.
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
@@ -1,8 +1,15 @@
 package data
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"math"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"greenlight.kerseeehuang.com/internal/validator"
 )
@@ -10,16 +17,115 @@ import (
 type Filters struct {
 	Page         int      // The page number of results
 	PageSize     int      // Size of one page
-	Sort         string   // Name of field by which returned records are sorted
+	Sort         string   // Comma-separated names of fields by which returned records are sorted
 	SortSafelist []string // List of field name
+	Cursor       string   // Opaque cursor of keyset pagination, used instead of Page if provided
 }
 
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	PrevCursor   string `json:"prev_cursor,omitempty"`
+}
+
+// cursor is a position in the sort order of records, from which the records after it, or
+// before it if Backward is true, are selected. It is sent to clients as an opaque string.
+type cursor struct {
+	Sort     string   `json:"s"`           // Sort the cursor is issued for
+	Values   []string `json:"v"`           // Values of the sort columns at the position as text
+	ID       int64    `json:"i"`           // ID at the position, the tie-breaker of the sort
+	Backward bool     `json:"b,omitempty"` // Select the records before the position
+}
+
+// position is the position of a record in the sort order.
+type position struct {
+	values []string
+	id     int64
+}
+
+// sortKey is a column of a sort with its direction.
+type sortKey struct {
+	column string
+	desc   bool
+}
+
+// maxSortKeys is the maximum number of fields in a sort.
+const maxSortKeys = 3
+
+// ErrInvalidCursor is returned when the cursor of Filters cannot be decoded for its sort.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// sortColumnValues holds the checks of the text values of the sort columns stored in cursors,
+// which report whether a value can be compared with the column in DB. The values of the
+// columns not listed are text.
+var sortColumnValues = map[string]func(value string) bool{
+	"id":         isBigint,
+	"version":    isInteger,
+	"year":       isInteger,
+	"runtime":    isInteger,
+	"rank":       isReal,
+	"create_at":  isTimestamp,
+	"created_at": isTimestamp,
+	"deleted_at": isTimestamp,
+}
+
+// isBigint reports whether value is the text of a bigint.
+func isBigint(value string) bool {
+	_, err := strconv.ParseInt(value, 10, 64)
+	return err == nil
+}
+
+// isInteger reports whether value is the text of an integer.
+func isInteger(value string) bool {
+	_, err := strconv.ParseInt(value, 10, 32)
+	return err == nil
+}
+
+// isReal reports whether value is the text of a finite real.
+func isReal(value string) bool {
+	f, err := strconv.ParseFloat(value, 32)
+	return err == nil && !math.IsInf(f, 0) && !math.IsNaN(f)
+}
+
+// isTimestamp reports whether value is the text of a timestamp with time zone in the ISO
+// date style, such as "2022-03-04 05:06:07.123456+00".
+func isTimestamp(value string) bool {
+	for _, layout := range []string{"2006-01-02 15:04:05.999999-07", "2006-01-02 15:04:05.999999-07:00"} {
+		if _, err := time.Parse(layout, value); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// isText reports whether value can be stored as text, which is valid UTF-8 without NUL.
+func isText(value string) bool {
+	return utf8.ValidString(value) && !strings.ContainsRune(value, 0)
+}
+
+// encode returns the opaque string of c.
+func (c cursor) encode() string {
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+// decodeCursor decodes the opaque string s of a cursor.
+func decodeCursor(s string) (*cursor, error) {
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var c cursor
+	err = json.Unmarshal(js, &c)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
 }
 
 // ValidateFilters validates Filters f and store the validation error into v.
@@ -28,27 +134,65 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.Page <= 10_000_000, "page", "must be less than 10 million")
 	v.Check(f.PageSize > 0, "page_size", "must be greater than 0")
 	v.Check(f.PageSize <= 100, "page_size", "must be less than 100")
-	v.Check(validator.In(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+
+	// Check each field of the sort.
+	keys := strings.Split(f.Sort, ",")
+	columns := make([]string, len(keys))
+	for i, key := range keys {
+		v.Check(validator.In(key, f.SortSafelist...), "sort", "invalid sort value")
+		columns[i] = strings.TrimPrefix(key, "-")
+	}
+	v.Check(len(keys) <= maxSortKeys, "sort", fmt.Sprintf("must not contain more than %d fields", maxSortKeys))
+	v.Check(validator.Unique(columns), "sort", "must not contain a field more than once")
+
+	// Check the cursor is issued for the same sort, and its values can be compared with the columns.
+	if f.Cursor != "" {
+		c, err := decodeCursor(f.Cursor)
+		v.Check(err == nil && len(c.Values) == len(keys), "cursor", "invalid cursor")
+		v.Check(err != nil || c.Sort == f.Sort, "cursor", "must be used with the sort it is issued for")
+		if err == nil && len(c.Values) == len(keys) {
+			for i, value := range c.Values {
+				valid, ok := sortColumnValues[columns[i]]
+				if !ok {
+					valid = isText
+				}
+				v.Check(valid(value), "cursor", "invalid cursor")
+			}
+		}
+		v.Check(f.Page == 1, "page", "must not be provided with cursor")
+	}
 }
 
-// sortColumn() checks that the client-provided sort field matches f.SortSafelist.
-// If it matches, return the sort field with the tripped leading hyphen.
-// If it does not match, then panic.
-func (f Filters) sortColumn() string {
-	for _, safeVal := range f.SortSafelist {
-		if f.Sort == safeVal {
-			return strings.TrimPrefix(f.Sort, "-")
+// sortKeys checks that each client-provided sort field matches f.SortSafelist.
+// If they match, return the columns of the fields with their directions.
+// If any does not match, then panic.
+func (f Filters) sortKeys() []sortKey {
+	var keys []sortKey
+	for _, key := range strings.Split(f.Sort, ",") {
+		if !validator.In(key, f.SortSafelist...) {
+			panic("unsafe sort parameter: " + key)
 		}
+		keys = append(keys, sortKey{column: strings.TrimPrefix(key, "-"), desc: strings.HasPrefix(key, "-")})
 	}
-	panic("unsafe sort parameter: " + f.Sort)
+	return keys
 }
 
-// sortDirection return "ASC" or "DESC" depending on f.Sort.
-func (f Filters) sortDirection() string {
-	if strings.HasPrefix(f.Sort, "-") {
-		return "DESC"
+// orderBy returns the ORDER BY clause of f, followed by tieBreaker in ascending order
+// to make the order stable. The order is reversed for a backward cursor, so that the
+// records nearest to the cursor come first.
+func (f Filters) orderBy(tieBreaker string) string {
+	keys := append(f.sortKeys(), sortKey{column: tieBreaker})
+	backward := f.backward()
+
+	terms := make([]string, len(keys))
+	for i, key := range keys {
+		direction := "ASC"
+		if key.desc != backward {
+			direction = "DESC"
+		}
+		terms[i] = key.column + " " + direction
 	}
-	return "ASC"
+	return strings.Join(terms, ", ")
 }
 
 // limit() returns f.PageSize.
@@ -62,6 +206,146 @@ func (f Filters) offset() int {
 	return (f.Page - 1) * f.PageSize
 }
 
+// cursor returns the decoded f.Cursor, or nil if f has no cursor.
+// Return ErrInvalidCursor if the cursor cannot be decoded for the sort of f.
+func (f Filters) cursor() (*cursor, error) {
+	if f.Cursor == "" {
+		return nil, nil
+	}
+	c, err := decodeCursor(f.Cursor)
+	if err != nil || len(c.Values) != len(f.sortKeys()) {
+		return nil, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// backward reports whether f selects the records before its cursor. An invalid cursor
+// is not backward, which is reported by keysetCondition instead.
+func (f Filters) backward() bool {
+	c, err := f.cursor()
+	return err == nil && c != nil && c.Backward
+}
+
+// positionColumns returns the SQL expressions selecting the values of the sort columns of f
+// as text, which are the positions of records stored in cursors. The values are named
+// position_1, position_2, etc., so that they do not shadow the columns.
+func (f Filters) positionColumns() string {
+	keys := f.sortKeys()
+	columns := make([]string, len(keys))
+	for i, key := range keys {
+		columns[i] = fmt.Sprintf("%s::text AS position_%d", key.column, i+1)
+	}
+	return strings.Join(columns, ", ")
+}
+
+// keysetCondition returns the SQL condition selecting the records after the cursor of f,
+// or before it for a backward cursor, in the order of f.orderBy("id"), with the placeholders
+// starting from $n, and the arguments of the placeholders. It returns "TRUE" if f has no cursor.
+// Return ErrInvalidCursor if the cursor of f is invalid.
+func (f Filters) keysetCondition(n int) (string, []interface{}, error) {
+	c, err := f.cursor()
+	if err != nil {
+		return "", nil, err
+	}
+	if c == nil {
+		return "TRUE", nil, nil
+	}
+
+	// Append the id as the last column and value.
+	keys := append(f.sortKeys(), sortKey{column: "id"})
+	args := make([]interface{}, 0, len(keys))
+	for _, value := range c.Values {
+		args = append(args, value)
+	}
+	args = append(args, c.ID)
+
+	// A record comes after the position if its columns equal the values of the position
+	// up to a column, which comes after the value of the position.
+	terms := make([]string, len(keys))
+	for i, key := range keys {
+		parts := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			parts = append(parts, fmt.Sprintf("%s = $%d", keys[j].column, n+j))
+		}
+		op := ">"
+		if key.desc != c.Backward {
+			op = "<"
+		}
+		parts = append(parts, fmt.Sprintf("%s %s $%d", key.column, op, n+i))
+		terms[i] = "(" + strings.Join(parts, " AND ") + ")"
+	}
+
+	return "(" + strings.Join(terms, " OR ") + ")", args, nil
+}
+
+// keysetLimit returns one more than f.PageSize, the extra record tells whether
+// there are more records after the page.
+func (f Filters) keysetLimit() int {
+	return f.PageSize + 1
+}
+
+// paginate returns the number of records forming the page among the records fetched with
+// f.orderBy("id") and f.keysetLimit(), whose positions are given in the fetched order, and the
+// metadata of the page with the cursors of the next and the previous pages.
+// The records fetched for a backward cursor must be reversed after trimming.
+// Return ErrInvalidCursor if the cursor of f is invalid.
+func (f Filters) paginate(totalRecords int, positions []position) (int, Metadata, error) {
+	c, err := f.cursor()
+	if err != nil {
+		return 0, Metadata{}, err
+	}
+
+	// Trim the extra record.
+	more := len(positions) > f.PageSize
+	n := len(positions)
+	if more {
+		n = f.PageSize
+	}
+
+	// Calculate the metadata of page numbers only without cursor, since the total
+	// records counted with a cursor excludes the records before the cursor.
+	var metadata Metadata
+	if c == nil {
+		metadata = calculateMatadata(totalRecords, f.Page, f.PageSize)
+	} else {
+		metadata = Metadata{PageSize: f.PageSize}
+	}
+
+	// Point back to the cursor from an empty page.
+	if n == 0 {
+		if c != nil {
+			back := cursor{Sort: f.Sort, Values: c.Values, ID: c.ID, Backward: !c.Backward}
+			if c.Backward {
+				metadata.NextCursor = back.encode()
+			} else {
+				metadata.PrevCursor = back.encode()
+			}
+		}
+		return 0, metadata, nil
+	}
+
+	// Find the first and the last records of the page in the sort order.
+	first, last := positions[0], positions[n-1]
+	hasPrev, hasNext := f.Page > 1, more
+	if c != nil {
+		hasPrev = true
+	}
+	if c != nil && c.Backward {
+		first, last = last, first
+		hasPrev, hasNext = more, true
+	}
+
+	// Create the cursors.
+	if hasNext {
+		metadata.NextCursor = cursor{Sort: f.Sort, Values: last.values, ID: last.id}.encode()
+	}
+	if hasPrev {
+		metadata.PrevCursor = cursor{Sort: f.Sort, Values: first.values, ID: first.id, Backward: true}.encode()
+	}
+
+	return n, metadata, nil
+}
+
 // calculateMatadata return a Metadata whose fields are
 // calculated by totalRecords, page and pageSize.
 func calculateMatadata(totalRecords, page, pageSize int) Metadata {
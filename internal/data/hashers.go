@@ -0,0 +1,204 @@
+package data
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Names of the password hashing algorithms.
+const (
+	HasherBcrypt       = "bcrypt"
+	HasherPBKDF2SHA256 = "pbkdf2-sha256"
+)
+
+// ErrUnknownPasswordHash is returned when a stored password hash has an unknown format.
+var ErrUnknownPasswordHash = errors.New("unknown password hash format")
+
+// PasswordHasher hashes passwords with an algorithm, and verifies passwords against
+// the hashes of the same algorithm. The algorithm and the parameters are encoded in
+// the hash, so that hashes created with other parameters can still be verified.
+type PasswordHasher interface {
+	// Name returns the name of the algorithm.
+	Name() string
+	// Hash returns the encoded hash of plaintext.
+	Hash(plaintext string) ([]byte, error)
+	// Identify reports whether the hash was created with the algorithm.
+	Identify(hash []byte) bool
+	// Verify reports whether plaintext matches the hash.
+	Verify(hash []byte, plaintext string) (bool, error)
+	// Outdated reports whether the hash was created with weaker parameters than the hasher's.
+	Outdated(hash []byte) bool
+}
+
+// passwordHashers holds the hasher used for new passwords and the hashers of all
+// supported algorithms used for verification.
+var passwordHashers = struct {
+	mu      sync.RWMutex
+	current PasswordHasher
+	all     []PasswordHasher
+}{
+	current: BcryptHasher{Cost: 12},
+	all:     []PasswordHasher{BcryptHasher{Cost: 12}, PBKDF2Hasher{Iterations: 600000}},
+}
+
+// SetPasswordHasher sets the hasher used for new passwords and replaces the hasher with the
+// same name used for verification. Passwords hashed with another algorithm or with weaker
+// parameters are rehashed with this hasher on the next successful login.
+func SetPasswordHasher(h PasswordHasher) {
+	passwordHashers.mu.Lock()
+	defer passwordHashers.mu.Unlock()
+
+	passwordHashers.current = h
+	for i, other := range passwordHashers.all {
+		if other.Name() == h.Name() {
+			passwordHashers.all[i] = h
+		}
+	}
+}
+
+// currentPasswordHasher returns the hasher used for new passwords.
+func currentPasswordHasher() PasswordHasher {
+	passwordHashers.mu.RLock()
+	defer passwordHashers.mu.RUnlock()
+	return passwordHashers.current
+}
+
+// passwordHasherFor returns the hasher which created hash.
+func passwordHasherFor(hash []byte) (PasswordHasher, error) {
+	passwordHashers.mu.RLock()
+	defer passwordHashers.mu.RUnlock()
+	for _, h := range passwordHashers.all {
+		if h.Identify(hash) {
+			return h, nil
+		}
+	}
+	return nil, ErrUnknownPasswordHash
+}
+
+// BcryptHasher hashes passwords with bcrypt in the modular crypt format, e.g. "$2a$12$...".
+type BcryptHasher struct {
+	Cost int
+}
+
+// Name returns the name of the algorithm.
+func (h BcryptHasher) Name() string {
+	return HasherBcrypt
+}
+
+// Hash returns the encoded hash of plaintext.
+func (h BcryptHasher) Hash(plaintext string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(plaintext), h.Cost)
+}
+
+// Identify reports whether the hash is a bcrypt hash.
+func (h BcryptHasher) Identify(hash []byte) bool {
+	return bytes.HasPrefix(hash, []byte("$2a$")) || bytes.HasPrefix(hash, []byte("$2b$")) || bytes.HasPrefix(hash, []byte("$2y$"))
+}
+
+// Verify reports whether plaintext matches the hash.
+func (h BcryptHasher) Verify(hash []byte, plaintext string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword(hash, []byte(plaintext))
+	if err != nil {
+		switch {
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Outdated reports whether the cost of the hash is lower than h.Cost.
+func (h BcryptHasher) Outdated(hash []byte) bool {
+	cost, err := bcrypt.Cost(hash)
+	return err != nil || cost < h.Cost
+}
+
+// PBKDF2Hasher hashes passwords with PBKDF2-HMAC-SHA256 (RFC 8018) in the format
+// "$pbkdf2-sha256$i=<iterations>$<salt>$<key>" with base64-encoded salt and key.
+type PBKDF2Hasher struct {
+	Iterations int
+}
+
+const (
+	pbkdf2Prefix  = "$" + HasherPBKDF2SHA256 + "$"
+	pbkdf2SaltLen = 16
+	pbkdf2KeyLen  = sha256.Size
+)
+
+// Name returns the name of the algorithm.
+func (h PBKDF2Hasher) Name() string {
+	return HasherPBKDF2SHA256
+}
+
+// Hash returns the encoded hash of plaintext.
+func (h PBKDF2Hasher) Hash(plaintext string) ([]byte, error) {
+	salt := make([]byte, pbkdf2SaltLen)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	key := pbkdf2.Key([]byte(plaintext), salt, h.Iterations, pbkdf2KeyLen, sha256.New)
+
+	enc := base64.RawStdEncoding
+	encoded := fmt.Sprintf("%si=%d$%s$%s", pbkdf2Prefix, h.Iterations, enc.EncodeToString(salt), enc.EncodeToString(key))
+	return []byte(encoded), nil
+}
+
+// Identify reports whether the hash is a PBKDF2-HMAC-SHA256 hash.
+func (h PBKDF2Hasher) Identify(hash []byte) bool {
+	return bytes.HasPrefix(hash, []byte(pbkdf2Prefix))
+}
+
+// Verify reports whether plaintext matches the hash.
+func (h PBKDF2Hasher) Verify(hash []byte, plaintext string) (bool, error) {
+	iterations, salt, key, err := parsePBKDF2Hash(hash)
+	if err != nil {
+		return false, err
+	}
+
+	actual := pbkdf2.Key([]byte(plaintext), salt, iterations, len(key), sha256.New)
+	return subtle.ConstantTimeCompare(actual, key) == 1, nil
+}
+
+// Outdated reports whether the iterations of the hash are fewer than h.Iterations.
+func (h PBKDF2Hasher) Outdated(hash []byte) bool {
+	iterations, _, _, err := parsePBKDF2Hash(hash)
+	return err != nil || iterations < h.Iterations
+}
+
+// parsePBKDF2Hash decodes the iterations, salt and key of an encoded PBKDF2 hash.
+func parsePBKDF2Hash(hash []byte) (iterations int, salt, key []byte, err error) {
+	parts := strings.Split(strings.TrimPrefix(string(hash), pbkdf2Prefix), "$")
+	if len(parts) != 3 || !strings.HasPrefix(parts[0], "i=") {
+		return 0, nil, nil, ErrUnknownPasswordHash
+	}
+
+	iterations, err = strconv.Atoi(strings.TrimPrefix(parts[0], "i="))
+	if err != nil || iterations < 1 {
+		return 0, nil, nil, ErrUnknownPasswordHash
+	}
+	salt, err = base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return 0, nil, nil, ErrUnknownPasswordHash
+	}
+	key, err = base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil || len(key) == 0 {
+		return 0, nil, nil, ErrUnknownPasswordHash
+	}
+
+	return iterations, salt, key, nil
+}
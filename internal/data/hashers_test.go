@@ -0,0 +1,87 @@
+package data
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+// TestPBKDF2RFC7914 checks the PBKDF2-HMAC-SHA256 test vectors of RFC 7914 Section 11.
+func TestPBKDF2RFC7914(t *testing.T) {
+	tests := []struct {
+		password   string
+		salt       string
+		iterations int
+		key        string
+	}{
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+	}
+
+	for _, tt := range tests {
+		key, err := hex.DecodeString(tt.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Encode the vector as a stored hash and verify the password against it.
+		enc := base64.RawStdEncoding
+		hash := []byte(fmt.Sprintf("%si=%d$%s$%s", pbkdf2Prefix, tt.iterations, enc.EncodeToString([]byte(tt.salt)), enc.EncodeToString(key)))
+		h := PBKDF2Hasher{Iterations: tt.iterations}
+
+		if ok, err := h.Verify(hash, tt.password); err != nil || !ok {
+			t.Errorf("Verify(%q, %d) = %v, %v, want true", tt.password, tt.iterations, ok, err)
+		}
+		if ok, _ := h.Verify(hash, tt.password+"x"); ok {
+			t.Errorf("Verify(%q) with a wrong password = true", tt.password)
+		}
+	}
+}
+
+// TestPasswordRehash checks that a bcrypt password needs rehashing once PBKDF2 is
+// the current hasher, and that the rehashed password still matches.
+func TestPasswordRehash(t *testing.T) {
+	defer SetPasswordHasher(currentPasswordHasher())
+
+	SetPasswordHasher(BcryptHasher{Cost: 4})
+	var p password
+	if err := p.Set("pa55word1234"); err != nil {
+		t.Fatal(err)
+	}
+	if p.NeedsRehash() {
+		t.Fatal("NeedsRehash() = true for the current hasher")
+	}
+
+	// Switch to PBKDF2. The bcrypt hash is still verified, and needs rehashing.
+	SetPasswordHasher(PBKDF2Hasher{Iterations: 1000})
+	if ok, err := p.Matches("pa55word1234"); err != nil || !ok {
+		t.Fatalf("Matches() = %v, %v, want true", ok, err)
+	}
+	if !p.NeedsRehash() {
+		t.Fatal("NeedsRehash() = false for a bcrypt hash")
+	}
+
+	// Rehash the password with PBKDF2.
+	if err := p.Set("pa55word1234"); err != nil {
+		t.Fatal(err)
+	}
+	if !(PBKDF2Hasher{}).Identify(p.hash) {
+		t.Fatalf("hash %q is not a PBKDF2 hash", p.hash)
+	}
+	if p.NeedsRehash() {
+		t.Error("NeedsRehash() = true after rehashing")
+	}
+	if ok, err := p.Matches("pa55word1234"); err != nil || !ok {
+		t.Errorf("Matches() = %v, %v, want true", ok, err)
+	}
+	if ok, _ := p.Matches("wrong password"); ok {
+		t.Error("Matches() = true for a wrong password")
+	}
+
+	// Raising the iterations makes the PBKDF2 hash outdated.
+	SetPasswordHasher(PBKDF2Hasher{Iterations: 2000})
+	if !p.NeedsRehash() {
+		t.Error("NeedsRehash() = false for fewer iterations")
+	}
+}
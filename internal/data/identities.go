@@ -0,0 +1,57 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// IdentityModel is a wrapper of DB connection pool. It links the users to their
+// identities at external identity providers.
+type IdentityModel struct {
+	DB *sql.DB
+}
+
+// Insert links the identity with subject at provider to the user.
+func (m IdentityModel) Insert(provider, subject string, userID int64) error {
+	query := `
+		INSERT INTO users_identities (provider, subject, user_id, created_at)
+		VALUES ($1, $2, $3, $4)`
+
+	args := []interface{}{provider, subject, userID, time.Now()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// GetUserID returns the ID of the user linked to the identity with subject at provider.
+// If the identity is not linked to any user, return data.ErrRecordNotFound.
+func (m IdentityModel) GetUserID(provider, subject string) (int64, error) {
+	// Prepare the query.
+	query := `
+		SELECT user_id
+		FROM users_identities
+		WHERE provider = $1 AND subject = $2`
+
+	// Prepare the context.
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// Execute the query.
+	var userID int64
+	err := m.DB.QueryRowContext(ctx, query, provider, subject).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return userID, nil
+}
@@ -0,0 +1,160 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// LoginFailures summarizes the failed logins of an account or an IP address in a period.
+type LoginFailures struct {
+	Count int       // Number of failed logins
+	Last  time.Time // Time of the last failed login, zero if Count is 0
+}
+
+// LoginModel is a wrapper of DB connection pool. It tracks failed logins and
+// lockouts by email address rather than by user, so that unknown email
+// addresses are treated in the same way as the existing ones.
+type LoginModel struct {
+	DB *sql.DB
+}
+
+// RecordFailure records a failed login to the account with email from ip.
+func (m LoginModel) RecordFailure(email, ip string) error {
+	query := `
+		INSERT INTO login_failures (email, ip, created_at)
+		VALUES ($1, $2, $3)`
+
+	args := []interface{}{email, ip, time.Now()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// GetFailuresForEmail returns the failed logins to the account with email since the given time.
+func (m LoginModel) GetFailuresForEmail(email string, since time.Time) (LoginFailures, error) {
+	query := `
+		SELECT count(*), COALESCE(max(created_at), 'epoch')
+		FROM login_failures
+		WHERE email = $1 AND created_at > $2`
+
+	return m.getFailures(query, email, since)
+}
+
+// GetFailuresForIP returns the failed logins from ip since the given time.
+func (m LoginModel) GetFailuresForIP(ip string, since time.Time) (LoginFailures, error) {
+	query := `
+		SELECT count(*), COALESCE(max(created_at), 'epoch')
+		FROM login_failures
+		WHERE ip = $1 AND created_at > $2`
+
+	return m.getFailures(query, ip, since)
+}
+
+// getFailures executes the query counting failed logins with given key and time.
+func (m LoginModel) getFailures(query, key string, since time.Time) (LoginFailures, error) {
+	// Prepare the context.
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// Execute the query.
+	var failures LoginFailures
+	err := m.DB.QueryRowContext(ctx, query, key, since).Scan(&failures.Count, &failures.Last)
+	if err != nil {
+		return LoginFailures{}, err
+	}
+	if failures.Count == 0 {
+		failures.Last = time.Time{}
+	}
+
+	return failures, nil
+}
+
+// ClearFailures deletes all failed logins to the account with email.
+func (m LoginModel) ClearFailures(email string) error {
+	query := `
+		DELETE FROM login_failures
+		WHERE email = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, email)
+	return err
+}
+
+// Lock locks the account with email until the given time.
+func (m LoginModel) Lock(email string, until time.Time) error {
+	query := `
+		INSERT INTO login_lockouts (email, locked_until)
+		VALUES ($1, $2)
+		ON CONFLICT (email) DO UPDATE SET locked_until = EXCLUDED.locked_until`
+
+	args := []interface{}{email, until}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// GetLockout returns the time until which the account with email is locked.
+// If the account is not locked, return data.ErrRecordNotFound.
+func (m LoginModel) GetLockout(email string) (time.Time, error) {
+	query := `
+		SELECT locked_until
+		FROM login_lockouts
+		WHERE email = $1 AND locked_until > $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	var lockedUntil time.Time
+	err := m.DB.QueryRowContext(ctx, query, email, time.Now()).Scan(&lockedUntil)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return time.Time{}, ErrRecordNotFound
+		default:
+			return time.Time{}, err
+		}
+	}
+
+	return lockedUntil, nil
+}
+
+// Unlock removes the lockout and the failed logins of the account with email.
+func (m LoginModel) Unlock(email string) error {
+	query := `
+		DELETE FROM login_lockouts
+		WHERE email = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, email)
+	if err != nil {
+		return err
+	}
+
+	return m.ClearFailures(email)
+}
+
+// DeleteExpired deletes the failed logins recorded before the given time and the expired lockouts.
+func (m LoginModel) DeleteExpired(before time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM login_failures WHERE created_at < $1`, before)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.DB.ExecContext(ctx, `DELETE FROM login_lockouts WHERE locked_until < $1`, time.Now())
+	return err
+}
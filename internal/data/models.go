@@ -8,22 +8,41 @@ import (
 var (
 	ErrRecordNotFound = errors.New("record not found") // record not found
 	ErrEditConflict   = errors.New("edit conflict")    // edit conflict
+	ErrLastAdmin      = errors.New("last admin")       // change would leave no activated admin
 )
 
 // Models holds all data models used in the whole project.
 type Models struct {
-	Movies      MovieModel
-	Permissions PermissionModel
-	Tokens      TokenModel
-	Users       UserModel
+	APIKeys        APIKeyModel
+	AuditEvents    AuditEventModel
+	Identities     IdentityModel
+	Logins         LoginModel
+	Movies         MovieModel
+	MovieRevisions MovieRevisionModel
+	OIDCStates     OIDCStateModel
+	Permissions    PermissionModel
+	Revocations    RevocationModel
+	Roles          RoleModel
+	TOTP           TOTPModel
+	Tokens         TokenModel
+	Users          UserModel
 }
 
 // NewModels return an instance of Models with given db.
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Movies:      MovieModel{DB: db},
-		Permissions: PermissionModel{DB: db},
-		Tokens:      TokenModel{DB: db},
-		Users:       UserModel{DB: db},
+		APIKeys:        APIKeyModel{DB: db},
+		AuditEvents:    AuditEventModel{DB: db},
+		Identities:     IdentityModel{DB: db},
+		Logins:         LoginModel{DB: db},
+		Movies:         MovieModel{DB: db},
+		MovieRevisions: MovieRevisionModel{DB: db},
+		OIDCStates:     OIDCStateModel{DB: db},
+		Permissions:    PermissionModel{DB: db},
+		Revocations:    RevocationModel{DB: db},
+		Roles:          RoleModel{DB: db},
+		TOTP:           TOTPModel{DB: db},
+		Tokens:         TokenModel{DB: db},
+		Users:          UserModel{DB: db},
 	}
 }
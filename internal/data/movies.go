@@ -13,13 +13,54 @@ import (
 
 // Movie stores all information of each movie.
 type Movie struct {
-	ID       int64     `json:"id"`
-	CreateAt time.Time `json:"-"` // Use `-` tag to unshow this field to users.
-	Title    string    `json:"title,omitempty"`
-	Year     int32     `json:"year,omitempty"`
-	Runtime  Runtime   `json:"runtime,omitempty"` // Movie runtime in minutes
-	Genres   []string  `json:"genres"`
-	Version  int32     `json:"version"`
+	ID        int64      `json:"id"`
+	CreateAt  time.Time  `json:"-"` // Use `-` tag to unshow this field to users.
+	Title     string     `json:"title,omitempty"`
+	Year      int32      `json:"year,omitempty"`
+	Runtime   Runtime    `json:"runtime,omitempty"` // Movie runtime in minutes
+	Genres    []string   `json:"genres"`
+	Language  string     `json:"language"` // Text-search language of the title, one of SearchLanguages
+	Version   int32      `json:"version"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"` // Time of moving the movie to trash, nil if not trashed.
+	Rank      float32    `json:"rank,omitempty"`       // Relevance to the search query, set in search results only.
+	Highlight string     `json:"highlight,omitempty"`  // Title with the matched words in <mark> tags, set in search results only.
+}
+
+// Modes of matching the genres of MovieFilter.
+const (
+	GenresMatchAll = "all" // movies with all of the genres
+	GenresMatchAny = "any" // movies with any of the genres
+)
+
+// MovieFilter holds the conditions of listing movies. Zero fields match all movies.
+type MovieFilter struct {
+	Title         string     // words in the title
+	Genres        []string   // genres matched in GenresMatch mode
+	GenresMatch   string     // GenresMatchAll or GenresMatchAny
+	ExcludeGenres []string   // genres none of which the movies have
+	YearMin       int        // minimum year
+	YearMax       int        // maximum year
+	RuntimeMin    int        // minimum runtime in minutes
+	RuntimeMax    int        // maximum runtime in minutes
+	CreatedSince  *time.Time // minimum creation time
+	Search        string     // full-text search query, the results are ranked by relevance
+	Prefix        bool       // match the last word of Search as a prefix for type-ahead
+}
+
+// ValidateMovieFilter validates filter and stores validation errors into v.
+func ValidateMovieFilter(v *validator.Validator, filter MovieFilter) {
+	v.Check(validator.In(filter.GenresMatch, GenresMatchAll, GenresMatchAny), "genres_match", "must be all or any")
+	v.Check(filter.YearMin >= 0, "year_min", "must not be negative")
+	v.Check(filter.YearMax >= 0, "year_max", "must not be negative")
+	v.Check(filter.YearMax == 0 || filter.YearMin <= filter.YearMax, "year_max", "must not be less than year_min")
+	v.Check(filter.RuntimeMin >= 0, "runtime_min", "must not be negative")
+	v.Check(filter.RuntimeMax >= 0, "runtime_max", "must not be negative")
+	v.Check(filter.RuntimeMax == 0 || filter.RuntimeMin <= filter.RuntimeMax, "runtime_max", "must not be less than runtime_min")
+	v.Check(len(filter.Genres) <= 20, "genres", "must not contain more than 20 genres")
+	v.Check(len(filter.ExcludeGenres) <= 20, "exclude_genres", "must not contain more than 20 genres")
+	v.Check(len(filter.Search) <= 200, "q", "must not be more than 200 bytes long")
+	v.Check(!filter.Prefix || filter.Search != "", "q", "must be provided for prefix search")
+	v.Check(filter.Search == "" || len(searchWords(filter.Search)) > 0, "q", "must contain a word")
 }
 
 // MovieModel is a wrapper of *sql.DB
@@ -29,23 +70,40 @@ type MovieModel struct {
 
 const dbTimeOut = 3 * time.Second // 3s timeout for all CRUD
 
-// Insert inserts a movie into DB.
-func (m MovieModel) Insert(movie *Movie) error {
+// Insert inserts a movie into DB, together with its first revision written by the
+// user with authorID. An authorID of 0 means the author is unknown.
+func (m MovieModel) Insert(movie *Movie, authorID int64) error {
 	// Define the sql query for inserting.
 	query := `
-		INSERT INTO movies (title, year, runtime, genres)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO movies (title, year, runtime, genres, language)
+		VALUES ($1, $2, $3, $4, $5)
 		RETURNING id, create_at, version`
 
 	// Declare arguments array for values in the above query.
-	args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+	args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), movie.Language}
 
 	// Create a context with 3-second timeout.
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
 	defer cancel()
 
-	// Execute query and return the returning error.
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreateAt, &movie.Version)
+	// Insert the movie and its revision in a transaction.
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreateAt, &movie.Version)
+	if err != nil {
+		return err
+	}
+
+	err = insertRevision(ctx, tx, movie, authorID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 // Get retrives a movie given movie id from DB.
@@ -57,9 +115,9 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 
 	// Define the sql query for getting.
 	query := `
-		SELECT id, create_at, title, year, runtime, genres, version
+		SELECT id, create_at, title, year, runtime, genres, language, version
 		FROM movies
-		Where id = $1`
+		Where id = $1 AND deleted_at IS NULL`
 
 	// Create time-out context.
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
@@ -74,6 +132,7 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		&movie.Year,
 		&movie.Runtime,
 		pq.Array(&movie.Genres),
+		&movie.Language,
 		&movie.Version,
 	)
 	if err != nil {
@@ -88,23 +147,95 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	return &movie, nil
 }
 
-// GetAll return a slice of movies based on given title, genres, and filters.
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
-	// Define the query of getting results.
+// query returns the SQL query selecting the movies matching filter, with their rank if filter
+// has a search query, and the arguments of its placeholders, which start from $1. The search
+// query is the argument $9, which is parsed per movie in its language.
+func (filter MovieFilter) query() (string, []interface{}) {
+	// Choose the operator of matching genres.
+	genresOp := "@>"
+	if filter.GenresMatch == GenresMatchAny {
+		genresOp = "&&"
+	}
+
+	// Create the argument array of the filters.
+	args := []interface{}{
+		filter.Title,
+		pq.Array(filter.Genres),
+		pq.Array(filter.ExcludeGenres),
+		filter.YearMin,
+		filter.YearMax,
+		filter.RuntimeMin,
+		filter.RuntimeMax,
+		filter.CreatedSince,
+	}
+
+	// Prepare the full-text search.
+	from, rank, search := "movies", "0::real", "TRUE"
+	if filter.Search != "" {
+		fn, tsquery := filter.tsquery()
+		args = append(args, tsquery)
+		from = fmt.Sprintf("movies, LATERAL %s(movies.language, $9) AS query", fn)
+		rank = "ts_rank(search_vector, query)"
+
+		// The search query parsed in all languages matches a superset of the movies matching
+		// the query parsed in their own languages, since it is a disjunction of those queries.
+		// Unlike the query of each movie, it does not depend on the row, so it selects the
+		// candidates with movies_search_vector_idx, and the exact match is checked after.
+		search = fmt.Sprintf("search_vector @@ (%s) AND search_vector @@ query", anyLanguageTSQuery(fn, "$9"))
+	}
+
 	query := fmt.Sprintf(`
-		SELECT count(*) OVER(), id, create_at, title, year, runtime, genres, version
-		FROM movies
+		SELECT id, create_at, title, year, runtime, genres, language, version, %s AS rank
+		FROM %s
 		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
-		AND (genres @> $2 OR $2 = '{}')
-		ORDER BY %s %s, id ASC
-		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+		AND (genres %s $2 OR $2 = '{}')
+		AND NOT (genres && $3)
+		AND (year >= $4 OR $4 = 0)
+		AND (year <= $5 OR $5 = 0)
+		AND (runtime >= $6 OR $6 = 0)
+		AND (runtime <= $7 OR $7 = 0)
+		AND (create_at >= $8 OR $8 IS NULL)
+		AND deleted_at IS NULL
+		AND %s`, rank, from, genresOp, search)
+
+	return query, args
+}
+
+// GetAll return a slice of movies based on given filter and filters.
+// If filter has a search query, the movies matching it in their text-search languages are
+// ranked and highlighted. The page is selected by the page number, or by the cursor of
+// filters if provided.
+func (m MovieModel) GetAll(filter MovieFilter, filters Filters) ([]*Movie, Metadata, error) {
+	// Prepare the query of the movies matching filter, and append the arguments of the page.
+	filterQuery, args := filter.query()
+	n := len(args)
+	args = append(args, filters.keysetLimit(), filters.offset())
+
+	// Define the query of getting results. The innermost query selects the movies with their rank,
+	// so that the rank can be sorted by and used in cursors like other columns. The outer query
+	// highlights the movies of the page only, since the highlights are expensive to make.
+	keyset, keysetArgs, err := filters.keysetCondition(len(args) + 1)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	args = append(args, keysetArgs...)
+	query := fmt.Sprintf(`
+		SELECT page.*, %s AS highlight
+		FROM (
+			SELECT count(*) OVER() AS total_records, id, create_at, title, year, runtime, genres, language, version, rank, %s
+			FROM (%s
+			) AS movies
+			WHERE %s
+			ORDER BY %s
+			LIMIT $%d OFFSET $%d
+		) AS page
+		ORDER BY %s`, filter.highlight(), filters.positionColumns(), filterQuery, keyset, filters.orderBy("id"), n+1, n+2, filters.orderBy("id"))
 
 	// Create a context with 3-second timeout.
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
 	defer cancel()
 
 	// Execute the query.
-	args := []interface{}{title, pq.Array(genres), filters.limit(), filters.offset()}
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, Metadata{}, err
@@ -114,9 +245,11 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	// Read the rows and store information into movies.
 	var totalRecords int
 	movies := []*Movie{}
+	positions := []position{}
 	for rows.Next() {
 		var movie Movie
-		err := rows.Scan(
+		sortValues := make([]string, len(filters.sortKeys()))
+		dest := []interface{}{
 			&totalRecords,
 			&movie.ID,
 			&movie.CreateAt,
@@ -124,12 +257,21 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 			&movie.Year,
 			&movie.Runtime,
 			pq.Array(&movie.Genres),
+			&movie.Language,
 			&movie.Version,
-		)
+			&movie.Rank,
+		}
+		for i := range sortValues {
+			dest = append(dest, &sortValues[i])
+		}
+		dest = append(dest, &movie.Highlight)
+		err := rows.Scan(dest...)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
+		movie.Highlight = escapeHighlight(movie.Highlight)
 		movies = append(movies, &movie)
+		positions = append(positions, position{values: sortValues, id: movie.ID})
 	}
 
 	// Check if any error happens during row scan.
@@ -137,20 +279,32 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 		return nil, Metadata{}, err
 	}
 
-	// Calculate the metadata based on totalRecords.
-	metadata := calculateMatadata(totalRecords, filters.Page, filters.PageSize)
+	// Trim the movies to the page and calculate the metadata.
+	n, metadata, err := filters.paginate(totalRecords, positions)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	movies = movies[:n]
+
+	// Put the movies fetched backward in the sort order.
+	if filters.backward() {
+		for i, j := 0, len(movies)-1; i < j; i, j = i+1, j-1 {
+			movies[i], movies[j] = movies[j], movies[i]
+		}
+	}
 
 	return movies, metadata, nil
 }
 
-// Update updates the information of given movie in DB.
+// Update updates the information of given movie in DB, and stores the new version as
+// a revision written by the user with authorID. An authorID of 0 means the author is unknown.
 // Return data.ErrEditConflict if conflict happens.
-func (m MovieModel) Update(movie *Movie) error {
+func (m MovieModel) Update(movie *Movie, authorID int64) error {
 	// Define the query of updating movie.
 	query := `
 		UPDATE movies
-		SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
-		WHERE id = $5 AND version = $6
+		SET title = $1, year = $2, runtime = $3, genres = $4, language = $5, version = version + 1
+		WHERE id = $6 AND version = $7 AND deleted_at IS NULL
 		RETURNING version`
 
 	// Create the argument array for the above query.
@@ -159,6 +313,7 @@ func (m MovieModel) Update(movie *Movie) error {
 		movie.Year,
 		movie.Runtime,
 		pq.Array(movie.Genres),
+		movie.Language,
 		movie.ID,
 		movie.Version,
 	}
@@ -167,8 +322,15 @@ func (m MovieModel) Update(movie *Movie) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
 	defer cancel()
 
+	// Update the movie and insert its revision in a transaction.
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	// Execute the query with arguments and scan the new version value into the movie struct.
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -178,19 +340,26 @@ func (m MovieModel) Update(movie *Movie) error {
 		}
 	}
 
-	return nil
+	err = insertRevision(ctx, tx, movie, authorID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-// Delete deletes the movie with given id from DB.
+// Delete moves the movie with given id to trash. Trashed movies are excluded from
+// Get and GetAll until they are restored, and are purged after the retention period.
 func (m MovieModel) Delete(id int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
-	// Define the query for deleting movie from DB.
+	// Define the query for moving the movie to trash.
 	query := `
-		DELETE FROM movies
-		WHERE id = $1`
+		UPDATE movies
+		SET deleted_at = NOW()
+		WHERE id = $1 AND deleted_at IS NULL`
 
 	// Create a context with 3-second timeout.
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
@@ -214,6 +383,124 @@ func (m MovieModel) Delete(id int64) error {
 	return nil
 }
 
+// Restore moves the trashed movie with given id out of trash and returns it.
+// Return nil, data.ErrRecordNotFound if there is no such trashed movie.
+func (m MovieModel) Restore(id int64) (*Movie, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	// Define the query for restoring the movie.
+	query := `
+		UPDATE movies
+		SET deleted_at = NULL
+		WHERE id = $1 AND deleted_at IS NOT NULL
+		RETURNING id, create_at, title, year, runtime, genres, language, version`
+
+	// Create a context with 3-second timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// Restore the movie and retrieve it.
+	var movie Movie
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&movie.ID,
+		&movie.CreateAt,
+		&movie.Title,
+		&movie.Year,
+		&movie.Runtime,
+		pq.Array(&movie.Genres),
+		&movie.Language,
+		&movie.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &movie, nil
+}
+
+// GetAllDeleted return a slice of trashed movies based on given filters.
+func (m MovieModel) GetAllDeleted(filters Filters) ([]*Movie, Metadata, error) {
+	// Define the query of getting results.
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, create_at, title, year, runtime, genres, language, version, deleted_at
+		FROM movies
+		WHERE deleted_at IS NOT NULL
+		ORDER BY %s
+		LIMIT $1 OFFSET $2`, filters.orderBy("id"))
+
+	// Create a context with 3-second timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// Execute the query.
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	// Read the rows and store information into movies.
+	var totalRecords int
+	movies := []*Movie{}
+	for rows.Next() {
+		var movie Movie
+		err := rows.Scan(
+			&totalRecords,
+			&movie.ID,
+			&movie.CreateAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Language,
+			&movie.Version,
+			&movie.DeletedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		movies = append(movies, &movie)
+	}
+
+	// Check if any error happens during row scan.
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	// Calculate the metadata based on totalRecords.
+	metadata := calculateMatadata(totalRecords, filters.Page, filters.PageSize)
+
+	return movies, metadata, nil
+}
+
+// Purge permanently deletes the movies moved to trash before the given time,
+// and returns the number of deleted movies.
+func (m MovieModel) Purge(before time.Time) (int64, error) {
+	// Prepare the query.
+	query := `
+		DELETE FROM movies
+		WHERE deleted_at <= $1`
+
+	// Prepare a context for executing the query.
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// Execute the query.
+	result, err := m.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
 // ValidateMove validates movie and store validation into v.Errors
 func ValidateMovie(v *validator.Validator, movie *Movie) {
 	// Check the title of movie.
@@ -235,4 +522,7 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 	v.Check(len(movie.Genres) >= 1, "genres", "must contain at least 1 genre")
 	v.Check(validator.Unique(movie.Genres), "genres", "values in genres must be unique")
 
+	// Check the text-search language of movie.
+	v.Check(validator.In(movie.Language, SearchLanguages...), "language", "must be a supported text-search language")
+
 }
@@ -0,0 +1,78 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// OIDCState holds the values bound to an OpenID Connect login between the redirect
+// to the provider and the callback.
+type OIDCState struct {
+	State        string // random value echoed back by the provider, stored as a hash
+	Nonce        string // random value expected in the ID token
+	CodeVerifier string // PKCE code verifier of the authorization code
+	Expiry       time.Time
+}
+
+// OIDCStateModel is a wrapper of DB connection pool.
+type OIDCStateModel struct {
+	DB *sql.DB
+}
+
+// Insert inserts state into DB, and deletes the expired states.
+func (m OIDCStateModel) Insert(state *OIDCState) error {
+	// Prepare the query and arguments.
+	query := `
+		INSERT INTO oidc_states (hash, nonce, code_verifier, expiry)
+		VALUES ($1, $2, $3, $4)`
+
+	hash := sha256.Sum256([]byte(state.State))
+	args := []interface{}{hash[:], state.Nonce, state.CodeVerifier, state.Expiry}
+
+	// Prepare the context.
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// Execute the queries.
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM oidc_states WHERE expiry < $1`, time.Now())
+	if err != nil {
+		return err
+	}
+
+	_, err = m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Consume deletes the unexpired state and returns it, so that a state can be used only once.
+// If no matching state is found in DB, return data.ErrRecordNotFound.
+func (m OIDCStateModel) Consume(state string) (*OIDCState, error) {
+	// Prepare the query and arguments.
+	query := `
+		DELETE FROM oidc_states
+		WHERE hash = $1 AND expiry > $2
+		RETURNING nonce, code_verifier, expiry`
+
+	hash := sha256.Sum256([]byte(state))
+	args := []interface{}{hash[:], time.Now()}
+
+	// Prepare the context.
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// Execute the query.
+	result := OIDCState{State: state}
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&result.Nonce, &result.CodeVerifier, &result.Expiry)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &result, nil
+}
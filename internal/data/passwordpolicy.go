@@ -0,0 +1,217 @@
+package data
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"greenlight.kerseeehuang.com/internal/validator"
+)
+
+// bundledBreachedPasswords holds the SHA-1 hashes of common passwords, one per line.
+//
+//go:embed breached_passwords.txt
+var bundledBreachedPasswords string
+
+// BreachedPasswords is a set of SHA-1 hashes of common or breached passwords. The hashes
+// of files are held in memory, and the range files of directories are read on demand.
+type BreachedPasswords struct {
+	hashes map[[sha1.Size]byte]struct{}
+	dirs   []string // directories of range files
+}
+
+// NewBreachedPasswords returns the set of the bundled common passwords.
+func NewBreachedPasswords() *BreachedPasswords {
+	b := &BreachedPasswords{hashes: make(map[[sha1.Size]byte]struct{})}
+	// The bundled list is known to be valid.
+	_ = b.read(strings.NewReader(bundledBreachedPasswords), "")
+	return b
+}
+
+// Load adds the hashes at path to b, in the formats of the k-anonymity range API of
+// Have I Been Pwned. If path is a directory, each file in it is named by the first 5
+// hexadecimal characters of the hashes (optionally with a .txt extension) and holds
+// the remaining 35 characters of a hash per line. Otherwise the file holds a full hash
+// per line. The hashes may be followed by ":count", which is ignored.
+// The file is read into memory, while the directory is only searched by Contains,
+// since a full range dump does not fit in memory.
+func (b *BreachedPasswords) Load(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	// Load a file of full hashes.
+	if !info.IsDir() {
+		return b.readFile(path, "")
+	}
+
+	// Check the directory can be read, and keep it for Contains.
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	f.Close()
+	b.dirs = append(b.dirs, path)
+
+	return nil
+}
+
+// Contains reports whether password is in b. The range file of the hash of password is
+// searched in each directory of b. A range file which cannot be read is skipped.
+func (b *BreachedPasswords) Contains(password string) bool {
+	hash := sha1.Sum([]byte(password))
+	if _, ok := b.hashes[hash]; ok {
+		return true
+	}
+
+	// Search the suffix of the hash in the range files of its prefix.
+	encoded := strings.ToUpper(hex.EncodeToString(hash[:]))
+	prefix, suffix := encoded[:5], encoded[5:]
+	for _, dir := range b.dirs {
+		for _, name := range []string{prefix, prefix + ".txt", strings.ToLower(prefix), strings.ToLower(prefix) + ".txt"} {
+			found, err := rangeFileContains(filepath.Join(dir, name), suffix)
+			if err == nil {
+				if found {
+					return true
+				}
+				break
+			}
+		}
+	}
+
+	return false
+}
+
+// rangeFileContains reports whether the range file at path holds the hash suffix,
+// which is in uppercase.
+func rangeFileContains(path, suffix string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		text := strings.TrimSpace(scanner.Text())
+		if i := strings.IndexByte(text, ':'); i >= 0 {
+			text = text[:i]
+		}
+		if strings.EqualFold(text, suffix) {
+			return true, nil
+		}
+	}
+
+	return false, scanner.Err()
+}
+
+// readFile adds the hashes in the file at path, each prefixed with prefix, to b.
+func (b *BreachedPasswords) readFile(path, prefix string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = b.read(f, prefix)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// read adds the hashes read from r, each prefixed with prefix, to b.
+func (b *BreachedPasswords) read(r io.Reader, prefix string) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		if i := strings.IndexByte(text, ':'); i >= 0 {
+			text = text[:i]
+		}
+
+		var hash [sha1.Size]byte
+		decoded, err := hex.DecodeString(prefix + text)
+		if err != nil || len(decoded) != sha1.Size {
+			return fmt.Errorf("line %d: invalid SHA-1 hash", line)
+		}
+		copy(hash[:], decoded)
+		b.hashes[hash] = struct{}{}
+	}
+
+	return scanner.Err()
+}
+
+// PasswordPolicy holds the requirements of new passwords in addition to ValidatePlainPassword.
+type PasswordPolicy struct {
+	MinLength         int                // minimum length in bytes
+	MinCharClasses    int                // minimum number of character classes among lowercase, uppercase, digits and symbols
+	DisallowPersonal  bool               // disallow passwords containing the email address or the name of the user
+	BreachedPasswords *BreachedPasswords // disallowed passwords, nil to skip the check
+}
+
+// Validate validates the new password of user against p and stores error messages into v.
+func (p *PasswordPolicy) Validate(v *validator.Validator, password string, user *User) {
+	ValidatePlainPassword(v, password)
+	if _, ok := v.Errors["password"]; ok {
+		return
+	}
+
+	v.Check(len(password) >= p.MinLength, "password", fmt.Sprintf("must be at least %d bytes long", p.MinLength))
+	v.Check(charClasses(password) >= p.MinCharClasses, "password", fmt.Sprintf("must contain at least %d of lowercase letters, uppercase letters, digits and symbols", p.MinCharClasses))
+	if p.DisallowPersonal && user != nil {
+		v.Check(!containsPersonal(password, user), "password", "must not contain your email address or name")
+	}
+	if p.BreachedPasswords != nil {
+		v.Check(!p.BreachedPasswords.Contains(password), "password", "is too common or has appeared in a data breach, please choose another one")
+	}
+}
+
+// charClasses returns the number of character classes among lowercase letters,
+// uppercase letters, digits and symbols in password.
+func charClasses(password string) int {
+	var lower, upper, digit, symbol int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	return lower + upper + digit + symbol
+}
+
+// containsPersonal reports whether password contains the local part of the email address,
+// the name or a word of the name of user, ignoring case. Parts shorter than 3 characters are ignored.
+func containsPersonal(password string, user *User) bool {
+	password = strings.ToLower(password)
+
+	parts := strings.Fields(user.Name)
+	parts = append(parts, user.Name)
+	if i := strings.LastIndexByte(user.Email, '@'); i > 0 {
+		parts = append(parts, user.Email[:i])
+	}
+
+	for _, part := range parts {
+		part = strings.ToLower(strings.TrimSpace(part))
+		if len(part) >= 3 && strings.Contains(password, part) {
+			return true
+		}
+	}
+	return false
+}
@@ -13,6 +13,7 @@ type Permissions []string
 const (
 	PermissionReadMovies  = "movies:read"
 	PermissionWriteMovies = "movies:write"
+	PermissionAdminUsers  = "users:admin"
 )
 
 // Include checks if s is in the permissions p.
@@ -25,20 +26,37 @@ func (p Permissions) Include(s string) bool {
 	return false
 }
 
+// Intersect returns the permissions in both p and q.
+func (p Permissions) Intersect(q Permissions) Permissions {
+	var permissions Permissions
+	for _, code := range p {
+		if q.Include(code) {
+			permissions = append(permissions, code)
+		}
+	}
+	return permissions
+}
+
 // PermissionModel is a wrapper of a DB connection pool.
 type PermissionModel struct {
 	DB *sql.DB
 }
 
-// GetAllForUser retrieves all permissions code from DB for the a user.
+// GetAllForUser retrieves all effective permissions code from DB for the a user,
+// which are the permissions granted to the user directly or by the roles of the user.
 func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 	// Prepare the query.
 	query := `
 		SELECT permissions.code
 		FROM permissions
 		INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
-		INNER JOIN users ON users_permissions.user_id = users.id
-		WHERE users.id = $1`
+		WHERE users_permissions.user_id = $1
+		UNION
+		SELECT permissions.code
+		FROM permissions
+		INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+		INNER JOIN users_roles ON users_roles.role_id = roles_permissions.role_id
+		WHERE users_roles.user_id = $1`
 
 	// Prepare the context.
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
@@ -70,12 +88,51 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 	return permissions, nil
 }
 
+// GetAll retrieves all permission codes from DB.
+func (m PermissionModel) GetAll() (Permissions, error) {
+	// Prepare the query.
+	query := `
+		SELECT code
+		FROM permissions
+		ORDER BY id`
+
+	// Prepare the context.
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// Execute the query.
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Copy the permission codes from result rows.
+	var permissions Permissions
+	for rows.Next() {
+		var permission string
+		err = rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+
+	// Return scan errors if there is any.
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
 // AddForUser add permission codes to given user.
 func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
 	// Prepare the query.
 	query := `
 		INSERT INTO users_permissions
-		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+		ON CONFLICT DO NOTHING`
 
 	// Prepare the context.
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
@@ -86,3 +143,91 @@ func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
 	_, err := m.DB.ExecContext(ctx, query, args...)
 	return err
 }
+
+// RemoveForUser removes the permission codes directly granted to given user.
+// Permissions granted by the roles of the user are not affected.
+// Return data.ErrLastAdmin if no activated user would hold PermissionAdminUsers afterwards.
+func (m PermissionModel) RemoveForUser(userID int64, codes ...string) error {
+	// Prepare the query.
+	query := `
+		DELETE FROM users_permissions
+		USING permissions
+		WHERE users_permissions.permission_id = permissions.id
+		AND users_permissions.user_id = $1
+		AND permissions.code = ANY($2)`
+
+	// Prepare the context.
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// Execute the query, keeping an admin.
+	args := []interface{}{userID, pq.Array(codes)}
+	return keepAdmin(ctx, m.DB, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, query, args...)
+		return err
+	})
+}
+
+// adminLockID is the key of the advisory lock serializing the changes which may remove
+// the last activated user holding PermissionAdminUsers.
+const adminLockID = 7231
+
+// countAdmins returns the number of activated users holding PermissionAdminUsers
+// directly or by their roles, excluding the users scheduled for deletion.
+func countAdmins(ctx context.Context, tx *sql.Tx) (int, error) {
+	query := `
+		SELECT count(*)
+		FROM users
+		WHERE activated AND deletion_scheduled_at IS NULL AND id IN (
+			SELECT users_permissions.user_id
+			FROM users_permissions
+			INNER JOIN permissions ON permissions.id = users_permissions.permission_id
+			WHERE permissions.code = $1
+			UNION
+			SELECT users_roles.user_id
+			FROM users_roles
+			INNER JOIN roles_permissions ON roles_permissions.role_id = users_roles.role_id
+			INNER JOIN permissions ON permissions.id = roles_permissions.permission_id
+			WHERE permissions.code = $1
+		)`
+
+	var count int
+	err := tx.QueryRowContext(ctx, query, PermissionAdminUsers).Scan(&count)
+	return count, err
+}
+
+// keepAdmin runs fn in a transaction, and rolls back the changes of fn with ErrLastAdmin
+// if they remove the last activated user holding PermissionAdminUsers. Such changes are
+// serialized, so that concurrent changes cannot remove the last admins together.
+func keepAdmin(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Serialize the changes.
+	_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, adminLockID)
+	if err != nil {
+		return err
+	}
+
+	// Count the admins before and after the changes.
+	before, err := countAdmins(ctx, tx)
+	if err != nil {
+		return err
+	}
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+	after, err := countAdmins(ctx, tx)
+	if err != nil {
+		return err
+	}
+	if before > 0 && after == 0 {
+		return ErrLastAdmin
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,186 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// MovieRevision stores the content of a version of a movie.
+type MovieRevision struct {
+	MovieID   int64     `json:"movie_id"`
+	Version   int32     `json:"version"`
+	Title     string    `json:"title"`
+	Year      int32     `json:"year"`
+	Runtime   Runtime   `json:"runtime"`
+	Genres    []string  `json:"genres"`
+	Language  string    `json:"language"`
+	AuthorID  *int64    `json:"author_id"` // nil if the author is unknown or deleted
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// MovieRevisionModel is a wrapper of DB connection pool. Revisions are inserted by
+// MovieModel together with the movie versions they store.
+type MovieRevisionModel struct {
+	DB *sql.DB
+}
+
+// insertRevision stores the current version of movie, written by the user with authorID,
+// in transaction tx. An authorID of 0 means the author is unknown.
+func insertRevision(ctx context.Context, tx *sql.Tx, movie *Movie, authorID int64) error {
+	query := `
+		INSERT INTO movie_revisions (movie_id, version, title, year, runtime, genres, language, author_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8::bigint, 0))`
+
+	args := []interface{}{movie.ID, movie.Version, movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), movie.Language, authorID}
+
+	_, err := tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Get returns the revision of the movie with given id and version.
+// Return nil, data.ErrRecordNotFound if there is no matching result in DB.
+func (m MovieRevisionModel) Get(movieID int64, version int32) (*MovieRevision, error) {
+	// Define the query of getting the revision.
+	query := `
+		SELECT movie_id, version, title, year, runtime, genres, language, author_id, created_at
+		FROM movie_revisions
+		WHERE movie_id = $1 AND version = $2`
+
+	// Create a context with 3-second timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// Retrieve the revision.
+	var revision MovieRevision
+	err := m.DB.QueryRowContext(ctx, query, movieID, version).Scan(
+		&revision.MovieID,
+		&revision.Version,
+		&revision.Title,
+		&revision.Year,
+		&revision.Runtime,
+		pq.Array(&revision.Genres),
+		&revision.Language,
+		&revision.AuthorID,
+		&revision.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &revision, nil
+}
+
+// GetAll returns the revisions of the movie with given id, and the pagination metadata.
+func (m MovieRevisionModel) GetAll(movieID int64, filters Filters) ([]*MovieRevision, Metadata, error) {
+	// Define the query of getting results.
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), movie_id, version, title, year, runtime, genres, language, author_id, created_at
+		FROM movie_revisions
+		WHERE movie_id = $1
+		ORDER BY %s
+		LIMIT $2 OFFSET $3`, filters.orderBy("version"))
+
+	// Create a context with 3-second timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// Execute the query.
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	// Read the rows and store information into revisions.
+	var totalRecords int
+	revisions := []*MovieRevision{}
+	for rows.Next() {
+		var revision MovieRevision
+		err := rows.Scan(
+			&totalRecords,
+			&revision.MovieID,
+			&revision.Version,
+			&revision.Title,
+			&revision.Year,
+			&revision.Runtime,
+			pq.Array(&revision.Genres),
+			&revision.Language,
+			&revision.AuthorID,
+			&revision.CreatedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		revisions = append(revisions, &revision)
+	}
+
+	// Check if any error happens during row scan.
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	// Calculate the metadata based on totalRecords.
+	metadata := calculateMatadata(totalRecords, filters.Page, filters.PageSize)
+
+	return revisions, metadata, nil
+}
+
+// GetAllForAuthor returns all revisions written by the user with authorID, ordered from
+// the oldest to the newest.
+func (m MovieRevisionModel) GetAllForAuthor(authorID int64) ([]*MovieRevision, error) {
+	// Define the query of getting results.
+	query := `
+		SELECT movie_id, version, title, year, runtime, genres, language, author_id, created_at
+		FROM movie_revisions
+		WHERE author_id = $1
+		ORDER BY created_at, movie_id, version`
+
+	// Create a context with 3-second timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// Execute the query.
+	rows, err := m.DB.QueryContext(ctx, query, authorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Read the rows and store information into revisions.
+	revisions := []*MovieRevision{}
+	for rows.Next() {
+		var revision MovieRevision
+		err := rows.Scan(
+			&revision.MovieID,
+			&revision.Version,
+			&revision.Title,
+			&revision.Year,
+			&revision.Runtime,
+			pq.Array(&revision.Genres),
+			&revision.Language,
+			&revision.AuthorID,
+			&revision.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, &revision)
+	}
+
+	// Check if any error happens during row scan.
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
@@ -0,0 +1,97 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Revocation is an entry of the deny-list of signed tokens, which cannot be deleted
+// from DB like the DB-backed tokens.
+// A revocation either revokes the single token with TokenID, or revokes all tokens of
+// the user with UserID that were issued before RevokedAt.
+type Revocation struct {
+	TokenID   string    // ID of the revoked token, empty if the revocation is for a user
+	UserID    int64     // ID of the user whose tokens are revoked, 0 if the revocation is for a token
+	RevokedAt time.Time // Time of revocation
+	Expiry    time.Time // Time after which all revoked tokens are expired, so the entry can be removed
+}
+
+// RevocationModel is a wrapper of DB connection pool.
+type RevocationModel struct {
+	DB *sql.DB
+}
+
+// Insert inserts the revocation into DB.
+func (m RevocationModel) Insert(revocation *Revocation) error {
+	// Prepare a query and arguments.
+	query := `
+		INSERT INTO revoked_tokens (token_id, user_id, revoked_at, expiry)
+		VALUES (NULLIF($1, ''), NULLIF($2, 0), $3, $4)`
+
+	args := []interface{}{revocation.TokenID, revocation.UserID, revocation.RevokedAt, revocation.Expiry}
+
+	// Prepare context for executing the query
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// Execute the query.
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// GetAllActive returns all revocations that are not expired yet.
+func (m RevocationModel) GetAllActive() ([]*Revocation, error) {
+	// Prepare the query.
+	query := `
+		SELECT COALESCE(token_id, ''), COALESCE(user_id, 0), revoked_at, expiry
+		FROM revoked_tokens
+		WHERE expiry > $1`
+
+	// Prepare the context.
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// Execute the query.
+	rows, err := m.DB.QueryContext(ctx, query, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Read the revocations from result rows.
+	revocations := []*Revocation{}
+	for rows.Next() {
+		var revocation Revocation
+		err := rows.Scan(
+			&revocation.TokenID,
+			&revocation.UserID,
+			&revocation.RevokedAt,
+			&revocation.Expiry,
+		)
+		if err != nil {
+			return nil, err
+		}
+		revocations = append(revocations, &revocation)
+	}
+
+	// Return scan errors if there is any.
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revocations, nil
+}
+
+// DeleteExpired deletes all expired revocations from DB.
+func (m RevocationModel) DeleteExpired() error {
+	query := `
+		DELETE FROM revoked_tokens
+		WHERE expiry <= $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, time.Now())
+	return err
+}
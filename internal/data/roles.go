@@ -0,0 +1,153 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+// Roles is a slice of role names.
+type Roles []string
+
+// Roles bundling permission codes.
+const (
+	RoleViewer = "viewer" // movies:read
+	RoleEditor = "editor" // movies:read, movies:write
+	RoleAdmin  = "admin"  // all permissions
+)
+
+// Include checks if s is in the roles r.
+func (r Roles) Include(s string) bool {
+	for _, r := range r {
+		if s == r {
+			return true
+		}
+	}
+	return false
+}
+
+// RoleModel is a wrapper of a DB connection pool.
+type RoleModel struct {
+	DB *sql.DB
+}
+
+// GetAllForUser retrieves the names of all roles from DB assigned to a user.
+func (m RoleModel) GetAllForUser(userID int64) (Roles, error) {
+	// Prepare the query.
+	query := `
+		SELECT roles.name
+		FROM roles
+		INNER JOIN users_roles ON users_roles.role_id = roles.id
+		WHERE users_roles.user_id = $1
+		ORDER BY roles.id`
+
+	// Prepare the context.
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// Execute the query.
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Copy the role names from result rows.
+	roles := Roles{}
+	for rows.Next() {
+		var role string
+		err = rows.Scan(&role)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+
+	// Return scan errors if there is any.
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// GetAll retrieves the names of all roles from DB.
+func (m RoleModel) GetAll() (Roles, error) {
+	// Prepare the query.
+	query := `
+		SELECT name
+		FROM roles
+		ORDER BY id`
+
+	// Prepare the context.
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// Execute the query.
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Copy the role names from result rows.
+	roles := Roles{}
+	for rows.Next() {
+		var role string
+		err = rows.Scan(&role)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+
+	// Return scan errors if there is any.
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// AddForUser assigns roles with given names to the user.
+// Roles that are already assigned to the user are skipped.
+func (m RoleModel) AddForUser(userID int64, names ...string) error {
+	// Prepare the query.
+	query := `
+		INSERT INTO users_roles
+		SELECT $1, roles.id FROM roles WHERE roles.name = ANY($2)
+		ON CONFLICT DO NOTHING`
+
+	// Prepare the context.
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// Execute the query
+	args := []interface{}{userID, pq.Array(names)}
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// RemoveForUser unassigns roles with given names from the user.
+// Return data.ErrLastAdmin if no activated user would hold PermissionAdminUsers afterwards.
+func (m RoleModel) RemoveForUser(userID int64, names ...string) error {
+	// Prepare the query.
+	query := `
+		DELETE FROM users_roles
+		USING roles
+		WHERE users_roles.role_id = roles.id
+		AND users_roles.user_id = $1
+		AND roles.name = ANY($2)`
+
+	// Prepare the context.
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// Execute the query, keeping an admin.
+	args := []interface{}{userID, pq.Array(names)}
+	return keepAdmin(ctx, m.DB, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, query, args...)
+		return err
+	})
+}
@@ -0,0 +1,142 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+)
+
+// SearchLanguages lists the text-search configurations of PostgreSQL which movies may use
+// for the stemming of their titles. "simple" does no stemming.
+var SearchLanguages = []string{
+	"simple", "danish", "dutch", "english", "finnish", "french", "german", "hungarian",
+	"italian", "norwegian", "portuguese", "romanian", "russian", "spanish", "swedish", "turkish",
+}
+
+// Markers of the matched words in the highlights of search results.
+const (
+	highlightStart = "<mark>"
+	highlightStop  = "</mark>"
+)
+
+// searchWords returns the words of a search query, ignoring punctuation and operators.
+func searchWords(search string) []string {
+	return strings.FieldsFunc(search, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// tsquery returns the name of the SQL function parsing the search query of filter and
+// the argument passed to it. Searches use the web search syntax with quoted phrases, "or"
+// and "-" for negation. Prefix searches match all words, and the last word as a prefix.
+func (filter MovieFilter) tsquery() (string, string) {
+	if !filter.Prefix {
+		return "websearch_to_tsquery", filter.Search
+	}
+
+	words := searchWords(filter.Search)
+	return "to_tsquery", strings.Join(words, " & ") + ":*"
+}
+
+// highlight returns the SQL expression of the title with the words matching the search query
+// of filter marked, or an empty string if filter has no search query. It uses the columns
+// language and title, and the search query in the argument $9 of filter.query().
+func (filter MovieFilter) highlight() string {
+	if filter.Search == "" {
+		return "''"
+	}
+
+	fn, _ := filter.tsquery()
+	return fmt.Sprintf("ts_headline(language, title, %s(language, $9), 'StartSel=%s, StopSel=%s, HighlightAll=true')", fn, highlightStart, highlightStop)
+}
+
+// anyLanguageTSQuery returns the SQL expression of the search query parsed with function fn
+// in all search languages, which matches the search vectors of movies in any language. The
+// expression is constant, hence it is able to use the index of the search vectors.
+func anyLanguageTSQuery(fn, placeholder string) string {
+	queries := make([]string, len(SearchLanguages))
+	for i, language := range SearchLanguages {
+		queries[i] = fmt.Sprintf("%s('%s', %s)", fn, language, placeholder)
+	}
+	return strings.Join(queries, " || ")
+}
+
+// escapeHighlight escapes the HTML in a highlight created by ts_headline, except the markers
+// of the matched words, so that the highlight is safe to be shown as HTML.
+func escapeHighlight(highlight string) string {
+	var b strings.Builder
+	for i, part := range strings.Split(highlight, highlightStart) {
+		if i > 0 {
+			b.WriteString(highlightStart)
+		}
+		for j, text := range strings.Split(part, highlightStop) {
+			if j > 0 {
+				b.WriteString(highlightStop)
+			}
+			b.WriteString(html.EscapeString(text))
+		}
+	}
+	return b.String()
+}
+
+// MovieSuggestion is a movie suggested for a partial or misspelled title.
+type MovieSuggestion struct {
+	ID    int64   `json:"id"`
+	Title string  `json:"title"`
+	Year  int32   `json:"year"`
+	Score float32 `json:"score"` // 1 for the titles starting with the query, or the trigram similarity to the query otherwise
+}
+
+// escapeLike escapes the wildcards of LIKE patterns in s.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// Suggest returns at most limit movies whose titles start with query, or contain words similar
+// to query for the misspelled titles, in the order of their scores.
+func (m MovieModel) Suggest(query string, limit int) ([]*MovieSuggestion, error) {
+	// Define the query of getting the suggestions. The titles starting with the query use the
+	// prefix index, and the similar titles use the trigram index.
+	stmt := `
+		SELECT id, title, year, score
+		FROM (
+			SELECT id, title, year,
+				CASE WHEN lower(title) LIKE $2 THEN 1 ELSE word_similarity($1, title) END AS score
+			FROM movies
+			WHERE (lower(title) LIKE $2 OR $1 <% title)
+			AND deleted_at IS NULL
+		) AS suggestions
+		ORDER BY score DESC, title ASC, id ASC
+		LIMIT $3`
+
+	// Create a context with 3-second timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// Execute the query.
+	rows, err := m.DB.QueryContext(ctx, stmt, query, escapeLike(strings.ToLower(query))+"%", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Read the rows and store information into suggestions.
+	suggestions := []*MovieSuggestion{}
+	for rows.Next() {
+		var suggestion MovieSuggestion
+		err := rows.Scan(&suggestion.ID, &suggestion.Title, &suggestion.Year, &suggestion.Score)
+		if err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, &suggestion)
+	}
+
+	// Check if any error happens during row scan.
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return suggestions, nil
+}
@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"errors"
 	"time"
 
 	"greenlight.kerseeehuang.com/internal/validator"
@@ -14,29 +15,40 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopePasswordReset  = "password-reset"
+	ScopeRefresh        = "refresh"
+	ScopeEmailChange    = "email-change"
+	ScopeMFA            = "mfa"
+	ScopeMagicLink      = "magic-link"
 )
 
-const (
-	TokenExpireTimeActivation     = time.Hour * 24 * 3
-	TokenExpireTimeAuthentication = time.Hour * 24
-)
+// ErrTokenReused is returned when a one-time-use token has already been used.
+var ErrTokenReused = errors.New("token reused")
 
 // Token holds attributes of a token stored in DB.
 type Token struct {
-	Plaintext string    `json:"token"` // Token string being sent to customer
-	Hash      []byte    `json:"-"`     // Hashed token being stored in DB
-	UserID    int64     `json:"-"`
-	Expiry    time.Time `json:"expiry"`
-	Scope     string    `json:"-"`
+	Plaintext  string     `json:"token,omitempty"` // Token string being sent to customer
+	Hash       []byte     `json:"-"`               // Hashed token being stored in DB
+	UserID     int64      `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Expiry     time.Time  `json:"expiry"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"` // nil if the token has never been used
+	IP         string     `json:"ip,omitempty"`           // IP address of the client the token is issued to
+	UserAgent  string     `json:"user_agent,omitempty"`   // User agent of the client the token is issued to
+	Scope      string     `json:"-"`
+	Family     []byte     `json:"-"` // Family of tokens issued from the same login, nil if no family
+	UsedAt     *time.Time `json:"-"` // Time when a one-time-use token was used
 }
 
 // generateToken generates a token based on given userID, expire time (ttl) and used scope.
 func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
 	// Create a token struct.
+	now := time.Now()
 	token := &Token{
-		UserID: userID,
-		Expiry: time.Now().Add(ttl),
-		Scope:  scope,
+		UserID:    userID,
+		CreatedAt: now,
+		Expiry:    now.Add(ttl),
+		Scope:     scope,
 	}
 
 	// Generate random bytes.
@@ -56,6 +68,16 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 	return token, nil
 }
 
+// NewTokenFamily generates a random identifier for a new token family.
+func NewTokenFamily() ([]byte, error) {
+	family := make([]byte, 16)
+	_, err := rand.Read(family)
+	if err != nil {
+		return nil, err
+	}
+	return family, nil
+}
+
 // validateTokenPlaintext validates the tokenPlaintext and store error messages into v.
 // It check that tokenPlaintext is not empty and is exactly 26 bytes long.
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
@@ -72,10 +94,10 @@ type TokenModel struct {
 func (m TokenModel) Insert(token *Token) error {
 	// Prepare a query and arguments.
 	query := `
-		INSERT INTO tokens (hash, user_id, expiry, scope)
-		VALUES ($1, $2, $3, $4)`
+		INSERT INTO tokens (hash, user_id, created_at, expiry, scope, ip, user_agent, family)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
 
-	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope}
+	args := []interface{}{token.Hash, token.UserID, token.CreatedAt, token.Expiry, token.Scope, token.IP, token.UserAgent, token.Family}
 
 	// Prepare context for executing the query
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
@@ -90,11 +112,25 @@ func (m TokenModel) Insert(token *Token) error {
 // New stores the new token into DB and also returns it.
 // If errors happen, return nil token and error.
 func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+	return m.NewForClient(userID, ttl, scope, "", "")
+}
+
+// NewForClient creates a new token like New, and records the IP address and
+// the user agent of the client that the token is issued to.
+func (m TokenModel) NewForClient(userID int64, ttl time.Duration, scope, ip, userAgent string) (*Token, error) {
+	return m.NewForFamily(nil, userID, ttl, scope, ip, userAgent)
+}
+
+// NewForFamily creates a new token like NewForClient, and puts it into the given token family.
+func (m TokenModel) NewForFamily(family []byte, userID int64, ttl time.Duration, scope, ip, userAgent string) (*Token, error) {
 	// Create a new token.
 	token, err := generateToken(userID, ttl, scope)
 	if err != nil {
 		return nil, err
 	}
+	token.IP = ip
+	token.UserAgent = userAgent
+	token.Family = family
 
 	// Insert the new token into DB.
 	err = m.Insert(token)
@@ -119,3 +155,235 @@ func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	_, err := m.DB.ExecContext(ctx, query, args...)
 	return err
 }
+
+// DeleteForPlaintext deletes the token with the given scope and tokenPlaintext.
+func (m TokenModel) DeleteForPlaintext(scope, tokenPlaintext string) error {
+	query := `
+		DELETE FROM tokens
+		WHERE hash = $1 AND scope = $2`
+
+	// Hash the tokenPlaintext.
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	args := []interface{}{tokenHash[:], scope}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Consume deletes the unexpired token with given scope and tokenPlaintext, and returns
+// the ID of its user. Since the token is deleted in the same statement, it can be
+// consumed only once even if concurrent requests present it.
+// If no matching token is found in DB, return data.ErrRecordNotFound.
+func (m TokenModel) Consume(scope, tokenPlaintext string) (int64, error) {
+	// Prepare the query.
+	query := `
+		DELETE FROM tokens
+		WHERE hash = $1 AND scope = $2 AND expiry > $3
+		RETURNING user_id`
+
+	// Hash the tokenPlaintext.
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	args := []interface{}{tokenHash[:], scope, time.Now()}
+
+	// Prepare the context.
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// Execute the query.
+	var userID int64
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return userID, nil
+}
+
+// GetAllForUser returns all unexpired tokens of the given user and specific scope,
+// ordered from the newest to the oldest.
+func (m TokenModel) GetAllForUser(scope string, userID int64) ([]*Token, error) {
+	// Prepare the query.
+	query := `
+		SELECT user_id, created_at, expiry, last_used_at, ip, user_agent, scope
+		FROM tokens
+		WHERE scope = $1 AND user_id = $2 AND expiry > $3
+		ORDER BY created_at DESC`
+
+	args := []interface{}{scope, userID, time.Now()}
+
+	// Prepare the context.
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// Execute the query.
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Read the tokens from result rows.
+	tokens := []*Token{}
+	for rows.Next() {
+		var token Token
+		err := rows.Scan(
+			&token.UserID,
+			&token.CreatedAt,
+			&token.Expiry,
+			&token.LastUsedAt,
+			&token.IP,
+			&token.UserAgent,
+			&token.Scope,
+		)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, &token)
+	}
+
+	// Return scan errors if there is any.
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// lastUsedResolution is the precision of the last used time of tokens and API keys. The last used time
+// is only written when it is older than this, so that not every request writes to DB.
+const lastUsedResolution = time.Minute
+
+// UpdateLastUsed sets the last used time of the token with given tokenPlaintext to now,
+// if it is older than lastUsedResolution.
+func (m TokenModel) UpdateLastUsed(tokenPlaintext string) error {
+	query := `
+		UPDATE tokens
+		SET last_used_at = $1
+		WHERE hash = $2 AND (last_used_at IS NULL OR last_used_at < $3)`
+
+	// Hash the tokenPlaintext.
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	now := time.Now()
+	args := []interface{}{now, tokenHash[:], now.Add(-lastUsedResolution)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// GetForPlaintext returns the unexpired token with the given scope and tokenPlaintext.
+// If no matching token is found in DB, return nil Token and data.ErrRecordNotFound.
+func (m TokenModel) GetForPlaintext(scope, tokenPlaintext string) (*Token, error) {
+	// Prepare the query.
+	query := `
+		SELECT hash, user_id, created_at, expiry, last_used_at, ip, user_agent, scope, family, used_at
+		FROM tokens
+		WHERE hash = $1 AND scope = $2 AND expiry > $3`
+
+	// Hash the tokenPlaintext.
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	args := []interface{}{tokenHash[:], scope, time.Now()}
+
+	// Prepare the context.
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// Execute the query and store the result into token.
+	token := Token{Plaintext: tokenPlaintext}
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&token.Hash,
+		&token.UserID,
+		&token.CreatedAt,
+		&token.Expiry,
+		&token.LastUsedAt,
+		&token.IP,
+		&token.UserAgent,
+		&token.Scope,
+		&token.Family,
+		&token.UsedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &token, nil
+}
+
+// MarkUsed marks the one-time-use token as used.
+// If the token has already been used, return data.ErrTokenReused.
+func (m TokenModel) MarkUsed(token *Token) error {
+	query := `
+		UPDATE tokens
+		SET used_at = $1
+		WHERE hash = $2 AND used_at IS NULL
+		RETURNING used_at`
+
+	args := []interface{}{time.Now(), token.Hash}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// The update only succeeds for the first use of the token, hence no
+	// returning row means that the token was used concurrently or before.
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&token.UsedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrTokenReused
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteFamily deletes all tokens of the given token family.
+func (m TokenModel) DeleteFamily(family []byte) error {
+	query := `
+		DELETE FROM tokens
+		WHERE family = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, family)
+	return err
+}
+
+// DeleteAllForUserExcept deletes all tokens for the given user and specific scope,
+// except the token keep and the tokens in the token family of keep.
+func (m TokenModel) DeleteAllForUserExcept(scope string, userID int64, keep *Token) error {
+	query := `
+		DELETE FROM tokens
+		WHERE scope = $1 AND user_id = $2
+		AND ($3::bytea IS NULL OR hash <> $3)
+		AND ($4::bytea IS NULL OR family IS NULL OR family <> $4)`
+
+	args := []interface{}{scope, userID, keep.Hash, keep.Family}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
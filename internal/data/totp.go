@@ -0,0 +1,238 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"regexp"
+	"time"
+
+	"greenlight.kerseeehuang.com/internal/validator"
+)
+
+// ErrCodeReused is returned when a TOTP code of a time step which has already been used is used again.
+var ErrCodeReused = errors.New("code reused")
+
+var (
+	totpCodeRX     = regexp.MustCompile(`^[0-9]{6}$`)
+	recoveryCodeRX = regexp.MustCompile(`^[a-z2-7]{5}-[a-z2-7]{5}$`)
+)
+
+// ValidateTOTPCode validates the TOTP code and stores error messages into v.
+func ValidateTOTPCode(v *validator.Validator, code string) {
+	v.Check(code != "", "code", validator.ErrMsgMustBeProvided)
+	v.Check(validator.Matches(code, totpCodeRX), "code", "must be 6 digits")
+}
+
+// ValidateSecondFactor validates that exactly one of the TOTP code and the recovery code
+// is provided in the right format, and stores error messages into v.
+func ValidateSecondFactor(v *validator.Validator, code, recoveryCode string) {
+	switch {
+	case code != "" && recoveryCode != "":
+		v.AddError("recovery_code", "must not be provided together with code")
+	case recoveryCode != "":
+		v.Check(validator.Matches(recoveryCode, recoveryCodeRX), "recovery_code", "invalid recovery code format")
+	default:
+		ValidateTOTPCode(v, code)
+	}
+}
+
+// TOTP holds the TOTP two-factor authentication settings of a user.
+type TOTP struct {
+	UserID    int64
+	Secret    string    // base32-encoded secret shared with the authenticator app
+	Enabled   bool      // false until the user confirms the enrollment with a valid code
+	LastStep  int64     // time step of the last accepted code
+	CreatedAt time.Time // time of the enrollment
+}
+
+// TOTPModel is a wrapper of DB connection pool.
+type TOTPModel struct {
+	DB *sql.DB
+}
+
+// Get returns the TOTP settings of the user.
+// If the user has not enrolled, return data.ErrRecordNotFound.
+func (m TOTPModel) Get(userID int64) (*TOTP, error) {
+	// Prepare the query.
+	query := `
+		SELECT user_id, secret, enabled, last_step, created_at
+		FROM users_totp
+		WHERE user_id = $1`
+
+	// Prepare the context.
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// Execute the query.
+	var totp TOTP
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&totp.UserID,
+		&totp.Secret,
+		&totp.Enabled,
+		&totp.LastStep,
+		&totp.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &totp, nil
+}
+
+// Enroll stores a new disabled TOTP secret for the user, replacing the unconfirmed
+// enrollment if there is one. If TOTP has been enabled for the user, return data.ErrEditConflict.
+func (m TOTPModel) Enroll(userID int64, secret string) error {
+	// Prepare the query.
+	query := `
+		INSERT INTO users_totp (user_id, secret, created_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, last_step = 0, created_at = EXCLUDED.created_at
+		WHERE users_totp.enabled = false`
+
+	args := []interface{}{userID, secret, time.Now()}
+
+	// Prepare the context.
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// Execute the query.
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrEditConflict
+	}
+
+	return nil
+}
+
+// Enable enables TOTP for the user and records the time step of the code which confirmed the enrollment.
+func (m TOTPModel) Enable(userID int64, step int64) error {
+	query := `
+		UPDATE users_totp
+		SET enabled = true, last_step = $2
+		WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, step)
+	return err
+}
+
+// UseStep records that the code of the time step has been used by the user.
+// If a code of this or a later time step has been used, return data.ErrCodeReused.
+func (m TOTPModel) UseStep(userID int64, step int64) error {
+	// Prepare the query.
+	query := `
+		UPDATE users_totp
+		SET last_step = $2
+		WHERE user_id = $1 AND last_step < $2`
+
+	// Prepare the context.
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// Execute the query.
+	result, err := m.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrCodeReused
+	}
+
+	return nil
+}
+
+// Delete deletes the TOTP settings and the recovery codes of the user.
+func (m TOTPModel) Delete(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.DB.ExecContext(ctx, `DELETE FROM users_totp WHERE user_id = $1`, userID)
+	return err
+}
+
+// ReplaceRecoveryCodes replaces all recovery codes of the user with the hashes of codes.
+func (m TOTPModel) ReplaceRecoveryCodes(userID int64, codes []string) error {
+	// Prepare the context.
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// Replace the codes in a transaction so that the user never ends up without codes.
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, code := range codes {
+		hash := sha256.Sum256([]byte(code))
+		_, err = tx.ExecContext(ctx, `INSERT INTO recovery_codes (hash, user_id) VALUES ($1, $2)`, hash[:], userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UseRecoveryCode marks the unused recovery code of the user as used.
+// If the user has no such unused code, return data.ErrRecordNotFound.
+func (m TOTPModel) UseRecoveryCode(userID int64, code string) error {
+	// Prepare the query.
+	query := `
+		UPDATE recovery_codes
+		SET used_at = $3
+		WHERE hash = $1 AND user_id = $2 AND used_at IS NULL`
+
+	hash := sha256.Sum256([]byte(code))
+	args := []interface{}{hash[:], userID, time.Now()}
+
+	// Prepare the context.
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// Execute the query.
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -5,9 +5,9 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
 	"greenlight.kerseeehuang.com/internal/validator"
 )
 
@@ -21,13 +21,15 @@ var AnonymousUser = &User{}
 
 // User holds information of a user.
 type User struct {
-	ID        int64     `json:"id"`
-	CreateAt  time.Time `json:"create_at"`
-	Name      string    `json:"name"`
-	Email     string    `json:"email"`
-	Password  password  `json:"-"`
-	Activated bool      `json:"activated"`
-	Version   int       `json:"-"`
+	ID                  int64      `json:"id"`
+	CreateAt            time.Time  `json:"create_at"`
+	Name                string     `json:"name"`
+	Email               string     `json:"email"`
+	Password            password   `json:"-"`
+	Activated           bool       `json:"activated"`
+	PendingEmail        *string    `json:"pending_email,omitempty"`         // New email address waiting for confirmation
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty"` // Time after which the user will be deleted
+	Version             int        `json:"version"`
 }
 
 // IsAnonymous returns true if the user u is an anonymous (inactivated) user.
@@ -40,16 +42,15 @@ type UserModel struct {
 	DB *sql.DB
 }
 
-const passwordCost int = 12 // cost of hashing password
-
 type password struct {
 	plaintext *string // password that a client input
-	hash      []byte  // hashed password
+	hash      []byte  // hashed password, encoded with the algorithm and the parameters
 }
 
-// Set hashes the plaintextPassword and stores it and hashed password into p.
+// Set hashes the plaintextPassword with the current password hasher and stores it and
+// hashed password into p.
 func (p *password) Set(plaintextPassword string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), passwordCost)
+	hash, err := currentPasswordHasher().Hash(plaintextPassword)
 	if err != nil {
 		return err
 	}
@@ -63,16 +64,39 @@ func (p *password) Set(plaintextPassword string) error {
 // It returns true if matches, and false otherwise.
 // Return non-nil error if there are problems during comparison.
 func (p *password) Matches(plaintextPassword string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
+	hasher, err := passwordHasherFor(p.hash)
 	if err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			return false, nil
-		default:
-			return false, err
-		}
+		return false, err
+	}
+	return hasher.Verify(p.hash, plaintextPassword)
+}
+
+// NeedsRehash reports whether the hashed password of p was created with another algorithm
+// than the current password hasher, or with weaker parameters.
+func (p *password) NeedsRehash() bool {
+	current := currentPasswordHasher()
+	return !current.Identify(p.hash) || current.Outdated(p.hash)
+}
+
+// dummyPassword is compared with the passwords of unknown users, so that the
+// response time of a login does not reveal whether a user exists.
+var dummyPassword password
+
+// PrepareDummyPassword hashes the dummy password with the current password hasher.
+// It must be called at startup after the password hasher is set.
+func PrepareDummyPassword() error {
+	return dummyPassword.Set("greenlight-dummy-password")
+}
+
+// SimulatePasswordMatch checks plaintextPassword against a dummy password, which takes
+// the same time as checking plaintextPassword against the password of a user.
+// It panics if the dummy password is not prepared, since a missing hash would be
+// compared in no time.
+func SimulatePasswordMatch(plaintextPassword string) {
+	if dummyPassword.hash == nil {
+		panic("data: dummy password is not prepared")
 	}
-	return true, nil
+	dummyPassword.Matches(plaintextPassword)
 }
 
 // ValidateEmail validates email and stores error information into v.
@@ -134,11 +158,112 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
+// Get returns the user with given id.
+// Return nil, data.ErrRecordNotFound if there is no matching result in DB.
+func (m UserModel) Get(id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	// Prepare a query.
+	query := `
+		SELECT id, create_at, name, email, password_hash, activated, pending_email, deletion_scheduled_at, version
+		FROM users
+		WHERE id = $1`
+
+	// Prepare a context for executing query
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// Execute the query and store results into user.
+	var user User
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreateAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.PendingEmail,
+		&user.DeletionScheduledAt,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+// GetAll returns a slice of users whose name and email contain the given name and email,
+// and whose activation matches activated if it is not nil, based on given filters.
+func (m UserModel) GetAll(name, email string, activated *bool, filters Filters) ([]*User, Metadata, error) {
+	// Define the query of getting results.
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, create_at, name, email, password_hash, activated, pending_email, deletion_scheduled_at, version
+		FROM users
+		WHERE (strpos(lower(name), lower($1)) > 0 OR $1 = '')
+		AND (strpos(lower(email), lower($2)) > 0 OR $2 = '')
+		AND (activated = $3 OR $3 IS NULL)
+		ORDER BY %s
+		LIMIT $4 OFFSET $5`, filters.orderBy("id"))
+
+	// Create a context with 3-second timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// Execute the query.
+	args := []interface{}{name, email, activated, filters.limit(), filters.offset()}
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	// Read the rows and store information into users.
+	var totalRecords int
+	users := []*User{}
+	for rows.Next() {
+		var user User
+		err := rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.CreateAt,
+			&user.Name,
+			&user.Email,
+			&user.Password.hash,
+			&user.Activated,
+			&user.PendingEmail,
+			&user.DeletionScheduledAt,
+			&user.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		users = append(users, &user)
+	}
+
+	// Check if any error happens during row scan.
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	// Calculate the metadata based on totalRecords.
+	metadata := calculateMatadata(totalRecords, filters.Page, filters.PageSize)
+
+	return users, metadata, nil
+}
+
 // GetByEmail return a user with given email.
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	// Prepare a query.
 	query := `
-		SELECT id, create_at, name, password_hash, activated, version
+		SELECT id, create_at, name, email, password_hash, activated, pending_email, deletion_scheduled_at, version
 		FROM users
 		WHERE email = $1`
 
@@ -152,8 +277,11 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		&user.ID,
 		&user.CreateAt,
 		&user.Name,
+		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.PendingEmail,
+		&user.DeletionScheduledAt,
 		&user.Version,
 	)
 	if err != nil {
@@ -169,18 +297,23 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 }
 
 // Update updates the record of user in the DB.
+// Return data.ErrEditConflict if the user has been updated since it was retrieved, and
+// data.ErrLastAdmin if deactivating the user or scheduling its deletion would leave no activated admin.
 func (m UserModel) Update(user *User) error {
 	// Prepare the query and arguments.
 	query := `
 		UPDATE users
-		SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
-		WHERE id = $5 AND version = $6
+		SET name = $1, email = $2, password_hash = $3, activated = $4, pending_email = $5,
+			deletion_scheduled_at = $6, version = version + 1
+		WHERE id = $7 AND version = $8
 		RETURNING version`
 	args := []interface{}{
 		user.Name,
 		user.Email,
 		user.Password.hash,
 		user.Activated,
+		user.PendingEmail,
+		user.DeletionScheduledAt,
 		user.ID,
 		user.Version,
 	}
@@ -189,14 +322,21 @@ func (m UserModel) Update(user *User) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
 	defer cancel()
 
-	// Execute the query.
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+	// Execute the query. A deactivated user or a user scheduled for deletion may have been the last admin.
+	var err error
+	if user.Activated && user.DeletionScheduledAt == nil {
+		err = m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+	} else {
+		err = keepAdmin(ctx, m.DB, func(tx *sql.Tx) error {
+			return tx.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+		})
+	}
 	if err != nil {
 		switch {
 		case err.Error() == pqErrDuplicateEmail:
 			return ErrDuplicateEmail
 		case errors.Is(err, sql.ErrNoRows):
-			return ErrRecordNotFound
+			return ErrEditConflict
 		default:
 			return err
 		}
@@ -210,7 +350,7 @@ func (m UserModel) Update(user *User) error {
 func (m UserModel) GetForToken(scope string, tokenPlaintext string) (*User, error) {
 	// Prepare the query.
 	query := `
-		SELECT users.id, users.create_at, users.name, users.email, users.password_hash, users.activated, users.version
+		SELECT users.id, users.create_at, users.name, users.email, users.password_hash, users.activated, users.pending_email, users.deletion_scheduled_at, users.version
 		FROM users
 		INNER JOIN tokens ON users.id = tokens.user_id
 		WHERE tokens.hash = $1
@@ -234,6 +374,8 @@ func (m UserModel) GetForToken(scope string, tokenPlaintext string) (*User, erro
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.PendingEmail,
+		&user.DeletionScheduledAt,
 		&user.Version,
 	)
 	if err != nil {
@@ -247,3 +389,43 @@ func (m UserModel) GetForToken(scope string, tokenPlaintext string) (*User, erro
 
 	return &user, nil
 }
+
+// DeleteScheduled deletes the users whose scheduled deletion time is at or before
+// the given time, and returns the number of deleted users.
+// The tokens, permissions and roles of the users are deleted by cascade, and the IP addresses
+// of the audit events of the users are cleared before their actors are cleared by the foreign key.
+// Return data.ErrLastAdmin if deleting the users would leave no activated admin.
+func (m UserModel) DeleteScheduled(before time.Time) (int64, error) {
+	// Prepare the queries.
+	scrubQuery := `
+		UPDATE audit_events
+		SET ip = ''
+		WHERE actor_id IN (SELECT id FROM users WHERE deletion_scheduled_at <= $1)`
+	query := `
+		DELETE FROM users
+		WHERE deletion_scheduled_at <= $1`
+
+	// Prepare a context for executing the query.
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	// Execute the query. The deleted users may include the last admin.
+	var deleted int64
+	err := keepAdmin(ctx, m.DB, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, scrubQuery, before)
+		if err != nil {
+			return err
+		}
+		result, err := tx.ExecContext(ctx, query, before)
+		if err != nil {
+			return err
+		}
+		deleted, err = result.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,255 @@
+// Package jwt implements signing and verification of JSON Web Tokens
+// (RFC 7519) in the JWS compact serialization.
+package jwt
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Signing algorithms supported by this package.
+const (
+	AlgorithmHS256 = "HS256" // HMAC with SHA-256
+	AlgorithmEdDSA = "EdDSA" // Ed25519 signature
+	AlgorithmRS256 = "RS256" // RSASSA-PKCS1-v1_5 with SHA-256, used by most OpenID Connect providers
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")         // malformed token or bad signature
+	ErrExpiredToken = errors.New("expired token")         // token has expired
+	ErrUnknownKey   = errors.New("unknown signing key")   // no key matches the key ID of the token
+	ErrNoSigningKey = errors.New("no signing key in set") // key set cannot sign tokens
+)
+
+// encoding is the base64url encoding without padding used by JWS.
+var encoding = base64.RawURLEncoding
+
+// header is the JOSE header of a token.
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+}
+
+// registeredClaims holds the registered claims checked during verification.
+type registeredClaims struct {
+	Expiry    *int64 `json:"exp"`
+	NotBefore *int64 `json:"nbf"`
+}
+
+// Key is a key used to sign or verify tokens with a key ID.
+type Key struct {
+	ID        string
+	Algorithm string
+	secret    []byte             // HMAC secret
+	private   ed25519.PrivateKey // Ed25519 private key, nil for verification-only keys
+	public    ed25519.PublicKey  // Ed25519 public key
+	rsaKey    *rsa.PrivateKey    // RSA private key, nil for verification-only keys
+	rsaPublic *rsa.PublicKey     // RSA public key
+}
+
+// NewHMACKey returns a HS256 key with given key ID and secret.
+// The secret must be at least 32 bytes long.
+func NewHMACKey(id string, secret []byte) (*Key, error) {
+	if len(secret) < sha256.Size {
+		return nil, fmt.Errorf("jwt: HMAC secret of key %q must be at least %d bytes", id, sha256.Size)
+	}
+	return &Key{ID: id, Algorithm: AlgorithmHS256, secret: secret}, nil
+}
+
+// NewEd25519Key returns an EdDSA key with given key ID, derived from the 32-byte seed.
+func NewEd25519Key(id string, seed []byte) (*Key, error) {
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("jwt: Ed25519 seed of key %q must be %d bytes", id, ed25519.SeedSize)
+	}
+	private := ed25519.NewKeyFromSeed(seed)
+	return &Key{
+		ID:        id,
+		Algorithm: AlgorithmEdDSA,
+		private:   private,
+		public:    private.Public().(ed25519.PublicKey),
+	}, nil
+}
+
+// NewRSAKey returns a RS256 key with given key ID and RSA private key.
+func NewRSAKey(id string, private *rsa.PrivateKey) (*Key, error) {
+	if private.N.BitLen() < 2048 {
+		return nil, fmt.Errorf("jwt: RSA key %q must be at least 2048 bits", id)
+	}
+	return &Key{ID: id, Algorithm: AlgorithmRS256, rsaKey: private, rsaPublic: &private.PublicKey}, nil
+}
+
+// NewRSAPublicKey returns a verification-only RS256 key with given key ID and RSA public key.
+func NewRSAPublicKey(id string, public *rsa.PublicKey) (*Key, error) {
+	if public.N.BitLen() < 2048 {
+		return nil, fmt.Errorf("jwt: RSA key %q must be at least 2048 bits", id)
+	}
+	return &Key{ID: id, Algorithm: AlgorithmRS256, rsaPublic: public}, nil
+}
+
+// canSign reports whether k holds the secret material needed for signing.
+func (k *Key) canSign() bool {
+	return k.secret != nil || k.private != nil || k.rsaKey != nil
+}
+
+// sign returns the signature of signingInput.
+func (k *Key) sign(signingInput string) ([]byte, error) {
+	switch k.Algorithm {
+	case AlgorithmHS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write([]byte(signingInput))
+		return mac.Sum(nil), nil
+	case AlgorithmEdDSA:
+		return ed25519.Sign(k.private, []byte(signingInput)), nil
+	case AlgorithmRS256:
+		digest := sha256.Sum256([]byte(signingInput))
+		return rsa.SignPKCS1v15(rand.Reader, k.rsaKey, crypto.SHA256, digest[:])
+	default:
+		return nil, fmt.Errorf("jwt: unsupported algorithm %q", k.Algorithm)
+	}
+}
+
+// verify reports whether signature is a valid signature of signingInput.
+func (k *Key) verify(signingInput string, signature []byte) bool {
+	switch k.Algorithm {
+	case AlgorithmHS256:
+		expected, _ := k.sign(signingInput)
+		return hmac.Equal(expected, signature)
+	case AlgorithmEdDSA:
+		return ed25519.Verify(k.public, []byte(signingInput), signature)
+	case AlgorithmRS256:
+		digest := sha256.Sum256([]byte(signingInput))
+		return rsa.VerifyPKCS1v15(k.rsaPublic, crypto.SHA256, digest[:], signature) == nil
+	default:
+		return false
+	}
+}
+
+// KeySet holds the keys used for verifying tokens, and the current key used for signing.
+// Keeping retired keys in the set allows rotating the signing key without
+// invalidating tokens that are signed by the previous keys.
+type KeySet struct {
+	current *Key
+	keys    map[string]*Key
+}
+
+// NewKeySet returns a KeySet of keys, which signs tokens with the key of ID current.
+// If current is empty, the key set can only verify tokens.
+func NewKeySet(current string, keys ...*Key) (*KeySet, error) {
+	ks := &KeySet{keys: make(map[string]*Key)}
+	for _, k := range keys {
+		if _, ok := ks.keys[k.ID]; ok {
+			return nil, fmt.Errorf("jwt: duplicate key ID %q", k.ID)
+		}
+		ks.keys[k.ID] = k
+	}
+
+	if current != "" {
+		k, ok := ks.keys[current]
+		if !ok || !k.canSign() {
+			return nil, fmt.Errorf("jwt: no signing key with ID %q", current)
+		}
+		ks.current = k
+	}
+
+	return ks, nil
+}
+
+// Sign encodes claims to JSON and returns the token signed by the current key of ks.
+func (ks *KeySet) Sign(claims interface{}) (string, error) {
+	if ks.current == nil {
+		return "", ErrNoSigningKey
+	}
+
+	// Encode the header and the claims.
+	h, err := json.Marshal(header{Algorithm: ks.current.Algorithm, Type: "JWT", KeyID: ks.current.ID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := encoding.EncodeToString(h) + "." + encoding.EncodeToString(payload)
+
+	// Sign the header and the claims.
+	signature, err := ks.current.sign(signingInput)
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + encoding.EncodeToString(signature), nil
+}
+
+// Verify verifies the signature, expiry and not-before time of token, and
+// decodes the claims of token into dst.
+// The key is selected by the key ID in the header of token, and the algorithm
+// in the header must match the algorithm of that key.
+func (ks *KeySet) Verify(token string, dst interface{}) error {
+	// Split the token into header, payload and signature.
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ErrInvalidToken
+	}
+
+	// Decode the header and find the key.
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return ErrInvalidToken
+	}
+	k, ok := ks.keys[h.KeyID]
+	if !ok {
+		return ErrUnknownKey
+	}
+	if h.Algorithm != k.Algorithm {
+		return ErrInvalidToken
+	}
+
+	// Verify the signature.
+	signature, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return ErrInvalidToken
+	}
+	if !k.verify(parts[0]+"."+parts[1], signature) {
+		return ErrInvalidToken
+	}
+
+	// Check the expiry and not-before time.
+	var rc registeredClaims
+	if err := decodeSegment(parts[1], &rc); err != nil {
+		return ErrInvalidToken
+	}
+	now := time.Now().Unix()
+	if rc.Expiry == nil || now >= *rc.Expiry {
+		return ErrExpiredToken
+	}
+	if rc.NotBefore != nil && now < *rc.NotBefore {
+		return ErrInvalidToken
+	}
+
+	// Decode the claims into dst.
+	if err := decodeSegment(parts[1], dst); err != nil {
+		return ErrInvalidToken
+	}
+
+	return nil
+}
+
+// decodeSegment decodes a base64url-encoded JSON segment of a token into dst.
+func decodeSegment(segment string, dst interface{}) error {
+	js, err := encoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(js, dst)
+}
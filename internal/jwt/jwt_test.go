@@ -0,0 +1,261 @@
+package jwt
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testClaims holds the claims of the tokens signed in the tests.
+type testClaims struct {
+	Subject   string `json:"sub"`
+	Expiry    int64  `json:"exp"`
+	NotBefore int64  `json:"nbf,omitempty"`
+}
+
+// mustDecode decodes the base64url string s, failing the test on errors.
+func mustDecode(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := encoding.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// newTestKeys returns a HS256 key and an EdDSA key for the tests.
+func newTestKeys(t *testing.T) (*Key, *Key) {
+	t.Helper()
+	hmacKey, err := NewHMACKey("hmac", bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	edKey, err := NewEd25519Key("ed", bytes.Repeat([]byte{2}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hmacKey, edKey
+}
+
+// TestRFC7515HS256 checks the HS256 example of RFC 7515 Appendix A.1.
+func TestRFC7515HS256(t *testing.T) {
+	secret := mustDecode(t, "AyM1SysPpbyDfgZld3umj1qzKObwVMkoqQ-EstJQLr_T-1qS0gZH75aKtMN3Yj0iPS4hcgUuTwjAzZr1Z9CAow")
+	token := "eyJ0eXAiOiJKV1QiLA0KICJhbGciOiJIUzI1NiJ9" +
+		".eyJpc3MiOiJqb2UiLA0KICJleHAiOjEzMDA4MTkzODAsDQogImh0dHA6Ly9leGFtcGxlLmNvbS9pc19yb290Ijp0cnVlfQ" +
+		".dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+
+	key, err := NewHMACKey("", secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Check the signature.
+	parts := strings.Split(token, ".")
+	signature, err := key.sign(parts[0] + "." + parts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := encoding.EncodeToString(signature); got != parts[2] {
+		t.Errorf("signature = %s, want %s", got, parts[2])
+	}
+
+	// The example token has a valid signature, but it expired in 2011.
+	ks, err := NewKeySet("", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var claims map[string]interface{}
+	if err := ks.Verify(token, &claims); !errors.Is(err, ErrExpiredToken) {
+		t.Errorf("Verify() error = %v, want %v", err, ErrExpiredToken)
+	}
+}
+
+// TestRFC8037EdDSA checks the Ed25519 example of RFC 8037 Appendix A.4.
+func TestRFC8037EdDSA(t *testing.T) {
+	seed := mustDecode(t, "nWGxne_9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A")
+	signingInput := "eyJhbGciOiJFZERTQSJ9.RXhhbXBsZSBvZiBFZDI1NTE5IHNpZ25pbmc"
+	want := "hgyY0il_MGCjP0JzlnLWG1PPOt7-09PGcvMg3AIbQR6dWbhijcNR4ki4iylGjg5BhVsPt9g7sVvpAr_MuM0KAg"
+
+	key, err := NewEd25519Key("", seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signature, err := key.sign(signingInput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := encoding.EncodeToString(signature); got != want {
+		t.Errorf("signature = %s, want %s", got, want)
+	}
+	if !key.verify(signingInput, signature) {
+		t.Error("verify() = false, want true")
+	}
+}
+
+// TestSignVerify checks that tokens signed by a key set are verified with their claims.
+func TestSignVerify(t *testing.T) {
+	hmacKey, edKey := newTestKeys(t)
+
+	for _, key := range []*Key{hmacKey, edKey} {
+		t.Run(key.Algorithm, func(t *testing.T) {
+			ks, err := NewKeySet(key.ID, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			token, err := ks.Sign(testClaims{Subject: "42", Expiry: time.Now().Add(time.Hour).Unix()})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var claims testClaims
+			if err := ks.Verify(token, &claims); err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			if claims.Subject != "42" {
+				t.Errorf("subject = %q, want %q", claims.Subject, "42")
+			}
+		})
+	}
+}
+
+// TestVerifyInvalid checks that tampered, expired and not yet valid tokens are rejected.
+func TestVerifyInvalid(t *testing.T) {
+	hmacKey, edKey := newTestKeys(t)
+	ks, err := NewKeySet("hmac", hmacKey, edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// sign signs claims with ks, failing the test on errors.
+	sign := func(claims testClaims) string {
+		token, err := ks.Sign(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	now := time.Now()
+	valid := sign(testClaims{Subject: "1", Expiry: now.Add(time.Hour).Unix()})
+	parts := strings.Split(valid, ".")
+
+	// Replace the payload of the valid token with the payload of another subject.
+	other := strings.Split(sign(testClaims{Subject: "2", Expiry: now.Add(time.Hour).Unix()}), ".")
+	tampered := parts[0] + "." + other[1] + "." + parts[2]
+
+	// Claim the HMAC key is an EdDSA key.
+	algHeader := encoding.EncodeToString([]byte(`{"alg":"EdDSA","typ":"JWT","kid":"hmac"}`))
+	wrongAlg := algHeader + "." + parts[1] + "." + parts[2]
+
+	// Use a key ID not in the key set.
+	kidHeader := encoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT","kid":"unknown"}`))
+	unknownKID := kidHeader + "." + parts[1] + "." + parts[2]
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"malformed", "not-a-token", ErrInvalidToken},
+		{"tampered payload", tampered, ErrInvalidToken},
+		{"truncated signature", valid[:len(valid)-2], ErrInvalidToken},
+		{"algorithm mismatch", wrongAlg, ErrInvalidToken},
+		{"unknown key ID", unknownKID, ErrUnknownKey},
+		{"expired", sign(testClaims{Subject: "1", Expiry: now.Add(-time.Second).Unix()}), ErrExpiredToken},
+		{"no expiry", sign(testClaims{Subject: "1"}), ErrExpiredToken},
+		{"not yet valid", sign(testClaims{Subject: "1", Expiry: now.Add(time.Hour).Unix(), NotBefore: now.Add(time.Minute).Unix()}), ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var claims testClaims
+			if err := ks.Verify(tt.token, &claims); !errors.Is(err, tt.want) {
+				t.Errorf("Verify() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// TestKeyRotation checks that tokens signed by a retired key are still verified
+// after the signing key is rotated, and not after the retired key is removed.
+func TestKeyRotation(t *testing.T) {
+	oldKey, err := NewHMACKey("2024-01", bytes.Repeat([]byte{3}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := NewHMACKey("2024-02", bytes.Repeat([]byte{4}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := testClaims{Subject: "7", Expiry: time.Now().Add(time.Hour).Unix()}
+
+	// Sign a token with the old key.
+	before, err := NewKeySet("2024-01", oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken, err := before.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotate to the new key, keeping the old key for verification.
+	rotated, err := NewKeySet("2024-02", oldKey, newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newToken, err := rotated.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var h header
+	if err := decodeSegment(strings.Split(newToken, ".")[0], &h); err != nil {
+		t.Fatal(err)
+	}
+	if h.KeyID != "2024-02" {
+		t.Errorf("kid = %q, want %q", h.KeyID, "2024-02")
+	}
+	for _, token := range []string{oldToken, newToken} {
+		var got testClaims
+		if err := rotated.Verify(token, &got); err != nil {
+			t.Errorf("Verify() error = %v", err)
+		}
+	}
+
+	// Remove the old key.
+	retired, err := NewKeySet("2024-02", newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got testClaims
+	if err := retired.Verify(oldToken, &got); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Verify() error = %v, want %v", err, ErrUnknownKey)
+	}
+}
+
+// TestNewKeySet checks the errors of creating key sets.
+func TestNewKeySet(t *testing.T) {
+	hmacKey, edKey := newTestKeys(t)
+
+	if _, err := NewKeySet("", hmacKey, hmacKey); err == nil {
+		t.Error("NewKeySet() with duplicate key IDs succeeded")
+	}
+	if _, err := NewKeySet("missing", hmacKey, edKey); err == nil {
+		t.Error("NewKeySet() with unknown signing key succeeded")
+	}
+
+	ks, err := NewKeySet("", hmacKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.Sign(testClaims{}); !errors.Is(err, ErrNoSigningKey) {
+		t.Errorf("Sign() error = %v, want %v", err, ErrNoSigningKey)
+	}
+
+	if _, err := NewHMACKey("short", make([]byte, 16)); err == nil {
+		t.Error("NewHMACKey() with a short secret succeeded")
+	}
+}
@@ -0,0 +1,322 @@
+// Package oidc implements the OpenID Connect authorization code flow with PKCE
+// (RFC 7636) as a relying party, including provider discovery and the verification
+// of ID tokens against the JSON Web Key Set of the provider.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"greenlight.kerseeehuang.com/internal/jwt"
+)
+
+var (
+	ErrInvalidIDToken = errors.New("oidc: invalid ID token")        // ID token fails verification
+	ErrExchangeFailed = errors.New("oidc: code exchange failed")    // token endpoint refused the code
+	ErrNoIDToken      = errors.New("oidc: no ID token in response") // token response has no ID token
+)
+
+// encoding is the base64url encoding without padding used by PKCE and JWK.
+var encoding = base64.RawURLEncoding
+
+// Config holds the client registration of the relying party at a provider.
+type Config struct {
+	Issuer       string   // issuer URL of the provider, used for discovery
+	ClientID     string   // client ID registered at the provider
+	ClientSecret string   // client secret, empty for public clients
+	RedirectURL  string   // URL of the callback receiving the authorization code
+	Scopes       []string // scopes requested in addition to "openid"
+}
+
+// Claims holds the claims of a verified ID token used for logging in.
+type Claims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	Expiry        int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+	Name          string   `json:"name"`
+}
+
+// audience is the "aud" claim, which is either a string or an array of strings.
+type audience []string
+
+// UnmarshalJSON decodes a string or an array of strings into a.
+func (a *audience) UnmarshalJSON(js []byte) error {
+	var s string
+	if err := json.Unmarshal(js, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+	var ss []string
+	if err := json.Unmarshal(js, &ss); err != nil {
+		return err
+	}
+	*a = ss
+	return nil
+}
+
+// discovery holds the provider metadata used by this package.
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider is an OpenID Connect provider discovered from its issuer URL.
+type Provider struct {
+	config   Config
+	metadata discovery
+	client   *http.Client
+
+	mu   sync.RWMutex
+	keys *jwt.KeySet // keys of the provider, refreshed when an unknown key ID is seen
+}
+
+// Discover fetches the metadata of the provider at config.Issuer and its signing keys.
+func Discover(ctx context.Context, config Config) (*Provider, error) {
+	p := &Provider{config: config, client: &http.Client{Timeout: 10 * time.Second}}
+
+	// Fetch the provider metadata.
+	wellKnown := strings.TrimSuffix(config.Issuer, "/") + "/.well-known/openid-configuration"
+	err := p.getJSON(ctx, wellKnown, &p.metadata)
+	if err != nil {
+		return nil, err
+	}
+	if p.metadata.Issuer != config.Issuer {
+		return nil, fmt.Errorf("oidc: issuer %q in metadata does not match %q", p.metadata.Issuer, config.Issuer)
+	}
+
+	// Fetch the signing keys.
+	err = p.refreshKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// NewState returns a random value suitable for the state, the nonce or the PKCE code verifier.
+func NewState() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// AuthCodeURL returns the URL of the authorization endpoint to which the user is redirected
+// to log in, with the state, the nonce and the S256 challenge of the PKCE code verifier.
+func (p *Provider) AuthCodeURL(state, nonce, verifier string) string {
+	challenge := sha256.Sum256([]byte(verifier))
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.config.ClientID)
+	params.Set("redirect_uri", p.config.RedirectURL)
+	params.Set("scope", strings.Join(append([]string{"openid"}, p.config.Scopes...), " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", encoding.EncodeToString(challenge[:]))
+	params.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(p.metadata.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.metadata.AuthorizationEndpoint + sep + params.Encode()
+}
+
+// Exchange exchanges the authorization code and the PKCE code verifier for the tokens of
+// the user, and returns the claims of the verified ID token issued with the nonce.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Claims, error) {
+	// Prepare the token request.
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("client_id", p.config.ClientID)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	// Send the request.
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return nil, fmt.Errorf("%w: %s: %s", ErrExchangeFailed, res.Status, body)
+	}
+
+	// Decode the response.
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	err = json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&tokens)
+	if err != nil {
+		return nil, err
+	}
+	if tokens.IDToken == "" {
+		return nil, ErrNoIDToken
+	}
+
+	return p.Verify(ctx, tokens.IDToken, nonce)
+}
+
+// Verify verifies the signature, expiry, issuer, audience and nonce of the ID token, and
+// returns its claims. If the token is signed by an unknown key, the keys of the provider are
+// refreshed once, since the provider may have rotated its keys.
+func (p *Provider) Verify(ctx context.Context, idToken, nonce string) (*Claims, error) {
+	// Verify the signature and the expiry.
+	var claims Claims
+	err := p.keySet().Verify(idToken, &claims)
+	if errors.Is(err, jwt.ErrUnknownKey) {
+		err = p.refreshKeys(ctx)
+		if err != nil {
+			return nil, err
+		}
+		err = p.keySet().Verify(idToken, &claims)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	// Check the issuer, the audience and the nonce.
+	if claims.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidIDToken, claims.Issuer)
+	}
+	found := false
+	for _, aud := range claims.Audience {
+		found = found || aud == p.config.ClientID
+	}
+	if !found {
+		return nil, fmt.Errorf("%w: client is not in the audience", ErrInvalidIDToken)
+	}
+	if claims.Nonce != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidIDToken)
+	}
+
+	return &claims, nil
+}
+
+// keySet returns the current keys of the provider.
+func (p *Provider) keySet() *jwt.KeySet {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.keys
+}
+
+// refreshKeys fetches the JSON Web Key Set of the provider and replaces the keys of p.
+// Keys of unsupported types or uses are skipped.
+func (p *Provider) refreshKeys(ctx context.Context) error {
+	var jwks struct {
+		Keys []struct {
+			KeyType   string `json:"kty"`
+			KeyID     string `json:"kid"`
+			Use       string `json:"use"`
+			Algorithm string `json:"alg"`
+			N         string `json:"n"`
+			E         string `json:"e"`
+		} `json:"keys"`
+	}
+	err := p.getJSON(ctx, p.metadata.JWKSURI, &jwks)
+	if err != nil {
+		return err
+	}
+
+	var keys []*jwt.Key
+	for _, k := range jwks.Keys {
+		if k.KeyType != "RSA" || (k.Use != "" && k.Use != "sig") || (k.Algorithm != "" && k.Algorithm != jwt.AlgorithmRS256) {
+			continue
+		}
+		public, err := parseRSAPublicKey(k.N, k.E)
+		if err != nil {
+			return fmt.Errorf("oidc: key %q: %w", k.KeyID, err)
+		}
+		key, err := jwt.NewRSAPublicKey(k.KeyID, public)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+
+	ks, err := jwt.NewKeySet("", keys...)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys = ks
+
+	return nil
+}
+
+// getJSON gets the JSON document at url and decodes it into dst.
+func (p *Provider) getJSON(ctx context.Context, url string, dst interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: get %s: %s", url, res.Status)
+	}
+
+	return json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(dst)
+}
+
+// parseRSAPublicKey returns the RSA public key with the base64url-encoded modulus n and exponent e.
+func parseRSAPublicKey(n, e string) (*rsa.PublicKey, error) {
+	nb, err := encoding.DecodeString(n)
+	if err != nil {
+		return nil, err
+	}
+	eb, err := encoding.DecodeString(e)
+	if err != nil {
+		return nil, err
+	}
+	exponent := new(big.Int).SetBytes(eb)
+	if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 || exponent.Int64() < 3 {
+		return nil, errors.New("invalid RSA exponent")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(nb), E: int(exponent.Int64())}, nil
+}
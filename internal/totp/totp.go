@@ -0,0 +1,123 @@
+// Package totp implements time-based one-time passwords (RFC 6238) compatible with
+// authenticator apps, and one-time recovery codes.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	Digits = 6                // number of digits of a code
+	Period = 30 * time.Second // lifetime of a code
+	Skew   = 1                // number of periods before and after now in which codes are accepted
+
+	secretSize = 20 // size of a secret in bytes, as recommended for HMAC-SHA1 by RFC 4226
+)
+
+// ErrInvalidSecret is returned when the secret is not valid base32.
+var ErrInvalidSecret = errors.New("totp: invalid secret")
+
+// encoding is the base32 encoding without padding used by authenticator apps.
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a random base32-encoded secret.
+func GenerateSecret() (string, error) {
+	secret := make([]byte, secretSize)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(secret), nil
+}
+
+// URI returns the otpauth:// URI of the secret for the account, which is usually
+// shown as a QR code to be scanned by authenticator apps.
+func URI(issuer, account, secret string) string {
+	label := url.PathEscape(issuer + ":" + account)
+
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(Digits))
+	params.Set("period", fmt.Sprint(int(Period.Seconds())))
+
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// Step returns the time step of t.
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period.Seconds())
+}
+
+// Code returns the code of the secret at the time step.
+func Code(secret string, step int64) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", ErrInvalidSecret
+	}
+
+	// Compute the HMAC of the time step counter.
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	// Truncate the HMAC dynamically as defined in RFC 4226.
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, value%mod), nil
+}
+
+// Validate checks the code against the secret at time t, accepting the codes of Skew
+// periods before and after t. It returns the matched time step, which should be stored
+// and rejected in later validations to prevent the code from being replayed.
+func Validate(secret, code string, t time.Time) (step int64, ok bool, err error) {
+	if len(code) != Digits {
+		return 0, false, nil
+	}
+
+	now := Step(t)
+	for s := now - Skew; s <= now+Skew; s++ {
+		expected, err := Code(secret, s)
+		if err != nil {
+			return 0, false, err
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return s, true, nil
+		}
+	}
+
+	return 0, false, nil
+}
+
+// GenerateRecoveryCodes returns n random recovery codes in the form of "xxxxx-xxxxx".
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+	for i := range codes {
+		b := make([]byte, 7)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(encoding.EncodeToString(b))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+	return codes, nil
+}
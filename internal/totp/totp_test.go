@@ -0,0 +1,162 @@
+package totp
+
+import (
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the base32 encoding of the SHA-1 seed "12345678901234567890" of RFC 4226 and RFC 6238.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// TestRFC4226 checks the HOTP values of RFC 4226 Appendix D.
+func TestRFC4226(t *testing.T) {
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+
+	for counter, code := range want {
+		got, err := Code(rfcSecret, int64(counter))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != code {
+			t.Errorf("Code(counter %d) = %s, want %s", counter, got, code)
+		}
+	}
+}
+
+// TestRFC6238 checks the SHA-1 test vectors of RFC 6238 Appendix B. The vectors have
+// 8 digits, hence only their last 6 digits are compared.
+func TestRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := tt.code[len(tt.code)-Digits:]; got != want {
+			t.Errorf("Code(time %d) = %s, want %s", tt.unix, got, want)
+		}
+	}
+}
+
+// TestValidate checks that codes within the skew are accepted with their time steps.
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+
+	tests := []struct {
+		name string
+		step int64
+		ok   bool
+	}{
+		{"current", step, true},
+		{"previous", step - Skew, true},
+		{"next", step + Skew, true},
+		{"too old", step - Skew - 1, false},
+		{"too new", step + Skew + 1, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := Code(rfcSecret, tt.step)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok, err := Validate(rfcSecret, code, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.ok {
+				t.Fatalf("Validate() ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && got != tt.step {
+				t.Errorf("Validate() step = %d, want %d", got, tt.step)
+			}
+		})
+	}
+
+	// Codes of the wrong length and invalid secrets are rejected.
+	if _, ok, _ := Validate(rfcSecret, "12345", now); ok {
+		t.Error("Validate() accepted a short code")
+	}
+	if _, _, err := Validate("not base32!", "123456", now); err != ErrInvalidSecret {
+		t.Errorf("Validate() error = %v, want %v", err, ErrInvalidSecret)
+	}
+}
+
+// TestGenerateSecret checks that generated secrets are decodable and can be validated.
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := encoding.DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != secretSize {
+		t.Errorf("secret size = %d, want %d", len(key), secretSize)
+	}
+
+	now := time.Now()
+	code, err := Code(secret, Step(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := Validate(secret, code, now); err != nil || !ok {
+		t.Errorf("Validate() = %v, %v, want true", ok, err)
+	}
+}
+
+// TestURI checks the parameters of the otpauth:// URI.
+func TestURI(t *testing.T) {
+	uri, err := url.Parse(URI("Greenlight", "alice@example.com", rfcSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/Greenlight:alice@example.com" {
+		t.Errorf("URI() = %s", uri)
+	}
+
+	want := map[string]string{"secret": rfcSecret, "issuer": "Greenlight", "algorithm": "SHA1", "digits": "6", "period": "30"}
+	for key, value := range want {
+		if got := uri.Query().Get(key); got != value {
+			t.Errorf("URI() %s = %q, want %q", key, got, value)
+		}
+	}
+}
+
+// TestGenerateRecoveryCodes checks the format and the uniqueness of recovery codes.
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 10 {
+		t.Fatalf("got %d codes, want 10", len(codes))
+	}
+
+	format := regexp.MustCompile(`^[a-z2-7]{5}-[a-z2-7]{5}$`)
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		if !format.MatchString(code) {
+			t.Errorf("code %q does not match %s", code, format)
+		}
+		if seen[code] {
+			t.Errorf("duplicate code %q", code)
+		}
+		seen[code] = true
+	}
+}
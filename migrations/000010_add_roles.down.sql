@@ -0,0 +1,10 @@
+-- Restore the permissions granted by roles as direct grants.
+INSERT INTO users_permissions
+SELECT DISTINCT users_roles.user_id, roles_permissions.permission_id
+FROM users_roles
+INNER JOIN roles_permissions ON users_roles.role_id = roles_permissions.role_id
+ON CONFLICT DO NOTHING;
+
+DROP TABLE IF EXISTS users_roles;
+DROP TABLE IF EXISTS roles_permissions;
+DROP TABLE IF EXISTS roles;
@@ -0,0 +1,48 @@
+CREATE TABLE IF NOT EXISTS roles (
+    id bigserial PRIMARY KEY,
+    name text UNIQUE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS roles_permissions (
+    role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS users_roles (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+    PRIMARY KEY (user_id, role_id)
+);
+
+INSERT INTO roles (name)
+VALUES ('viewer'), ('editor'), ('admin');
+
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id FROM roles, permissions
+WHERE (roles.name = 'viewer' AND permissions.code = 'movies:read')
+OR (roles.name IN ('editor', 'admin') AND permissions.code IN ('movies:read', 'movies:write'));
+
+-- Map the existing users to roles by their permissions.
+INSERT INTO users_roles
+SELECT DISTINCT users_permissions.user_id, roles.id
+FROM users_permissions
+INNER JOIN permissions ON users_permissions.permission_id = permissions.id
+INNER JOIN roles ON roles.name = 'editor'
+WHERE permissions.code = 'movies:write';
+
+INSERT INTO users_roles
+SELECT DISTINCT users_permissions.user_id, roles.id
+FROM users_permissions
+INNER JOIN permissions ON users_permissions.permission_id = permissions.id
+INNER JOIN roles ON roles.name = 'viewer'
+WHERE permissions.code = 'movies:read'
+AND users_permissions.user_id NOT IN (SELECT user_id FROM users_roles);
+
+-- Remove the direct grants which are now covered by the roles.
+DELETE FROM users_permissions
+WHERE (user_id, permission_id) IN (
+    SELECT users_roles.user_id, roles_permissions.permission_id
+    FROM users_roles
+    INNER JOIN roles_permissions ON users_roles.role_id = roles_permissions.role_id
+);
@@ -0,0 +1,6 @@
+INSERT INTO permissions (code)
+VALUES ('users:admin');
+
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id FROM roles, permissions
+WHERE roles.name = 'admin' AND permissions.code = 'users:admin';
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS login_failures (
+    id bigserial PRIMARY KEY,
+    email citext NOT NULL,
+    ip text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS login_failures_email_idx ON login_failures (email, created_at);
+CREATE INDEX IF NOT EXISTS login_failures_ip_idx ON login_failures (ip, created_at);
+
+CREATE TABLE IF NOT EXISTS login_lockouts (
+    email citext PRIMARY KEY,
+    locked_until timestamp(0) with time zone NOT NULL
+);
@@ -0,0 +1,6 @@
+ALTER TABLE movie_revisions DROP COLUMN IF EXISTS language;
+
+DROP INDEX IF EXISTS movies_search_vector_idx;
+
+ALTER TABLE movies DROP COLUMN IF EXISTS search_vector;
+ALTER TABLE movies DROP COLUMN IF EXISTS language;
@@ -0,0 +1,6 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS language regconfig NOT NULL DEFAULT 'simple';
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (to_tsvector(language, title)) STORED;
+
+CREATE INDEX IF NOT EXISTS movies_search_vector_idx ON movies USING GIN (search_vector);
+
+ALTER TABLE movie_revisions ADD COLUMN IF NOT EXISTS language regconfig NOT NULL DEFAULT 'simple';
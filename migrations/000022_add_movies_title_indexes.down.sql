@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS movies_title_prefix_idx;
+DROP INDEX IF EXISTS movies_title_trgm_idx;
@@ -0,0 +1 @@
+ALTER TABLE revoked_tokens ALTER COLUMN revoked_at TYPE timestamp with time zone;
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS movie_revisions_author_id_idx;
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS movie_revisions_author_id_idx ON movie_revisions (author_id);
@@ -0,0 +1 @@
+ALTER TABLE audit_events DROP CONSTRAINT IF EXISTS audit_events_actor_id_fkey;
@@ -0,0 +1,4 @@
+UPDATE audit_events SET actor_id = NULL, ip = ''
+WHERE actor_id IS NOT NULL AND actor_id NOT IN (SELECT id FROM users);
+
+ALTER TABLE audit_events ADD CONSTRAINT audit_events_actor_id_fkey FOREIGN KEY (actor_id) REFERENCES users ON DELETE SET NULL;